@@ -6,6 +6,16 @@ import (
 	"fmt"
 	"math/rand"
 	"time"
+
+	grpcbackoff "google.golang.org/grpc/backoff"
+)
+
+// Supported backoff strategies.
+const (
+	StrategyExponential = "exponential" // double the delay range after each retry, with jitter
+	StrategyLinear      = "linear"      // add MinBackoff to the delay after each retry
+	StrategyConstant    = "constant"    // always wait MinBackoff
+	StrategyErrorAware  = "error-aware" // escalate faster on streaks of the same error, see ErrorAwareBackoff
 )
 
 // Config configures a Backoff
@@ -13,6 +23,8 @@ type Config struct {
 	MinBackoff time.Duration `yaml:"min_period"`  // start backoff at this level
 	MaxBackoff time.Duration `yaml:"max_period"`  // increase exponentially to this level
 	MaxRetries int           `yaml:"max_retries"` // give up after this many; zero means infinite retries
+	Strategy   string        `yaml:"strategy"`    // how the delay grows between retries; empty means exponential
+	Jitter     time.Duration `yaml:"jitter"`      // randomly shift the constant strategy delays by up to this much, e.g. for polling
 }
 
 // RegisterFlagsWithPrefix for Config.
@@ -20,6 +32,39 @@ func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.DurationVar(&cfg.MinBackoff, prefix+".backoff-min-period", 100*time.Millisecond, "Minimum delay when backing off.")
 	f.DurationVar(&cfg.MaxBackoff, prefix+".backoff-max-period", 10*time.Second, "Maximum delay when backing off.")
 	f.IntVar(&cfg.MaxRetries, prefix+".backoff-retries", 10, "Number of times to backoff and retry before failing.")
+	f.StringVar(&cfg.Strategy, prefix+".backoff-strategy", StrategyExponential, "Backoff strategy. Supported values are: 'exponential', 'linear', 'constant' and 'error-aware'.")
+	f.DurationVar(&cfg.Jitter, prefix+".backoff-jitter", 0, "Randomly shift each delay of the constant backoff strategy by up to this duration, more or less, to avoid synchronized retries or polls. 0 means no jitter.")
+}
+
+// Validate the Config.
+func (cfg *Config) Validate() error {
+	if cfg.Jitter < 0 {
+		return fmt.Errorf("backoff jitter can't be negative: %s", cfg.Jitter)
+	}
+	switch cfg.Strategy {
+	case "", StrategyExponential, StrategyLinear, StrategyConstant, StrategyErrorAware:
+		return nil
+	default:
+		return fmt.Errorf("unsupported backoff strategy: %s", cfg.Strategy)
+	}
+}
+
+// ToGRPCBackoffConfig returns the gRPC backoff config equivalent to the Config, to drive
+// the reconnection backoff of gRPC connections through grpc.ConnectParams. The multiplier
+// and jitter are the gRPC defaults, except for the constant strategy which doesn't grow
+// the delay. The linear strategy can't be expressed, and grows exponentially.
+func (cfg Config) ToGRPCBackoffConfig() grpcbackoff.Config {
+	grpcCfg := grpcbackoff.Config{
+		BaseDelay:  cfg.MinBackoff,
+		Multiplier: grpcbackoff.DefaultConfig.Multiplier,
+		Jitter:     grpcbackoff.DefaultConfig.Jitter,
+		MaxDelay:   cfg.MaxBackoff,
+	}
+	if cfg.Strategy == StrategyConstant {
+		grpcCfg.Multiplier = 1
+		grpcCfg.MaxDelay = cfg.MinBackoff
+	}
+	return grpcCfg
 }
 
 // Backoff implements exponential backoff with randomized wait times
@@ -41,6 +86,12 @@ func New(ctx context.Context, cfg Config) *Backoff {
 	}
 }
 
+// Clone returns a new Backoff with the same Config and Context, starting from its initial
+// condition. It's useful to give each target of a fan-out operation its own backoff.
+func (b *Backoff) Clone() *Backoff {
+	return New(b.ctx, b.cfg)
+}
+
 // Reset the Backoff back to its initial condition
 func (b *Backoff) Reset() {
 	b.numRetries = 0
@@ -87,6 +138,13 @@ func (b *Backoff) Wait() {
 func (b *Backoff) NextDelay() time.Duration {
 	b.numRetries++
 
+	switch b.cfg.Strategy {
+	case StrategyConstant:
+		return b.nextConstantDelay()
+	case StrategyLinear:
+		return b.nextLinearDelay()
+	}
+
 	// Handle the edge case where the min and max have the same value
 	// (or due to some misconfig max is < min)
 	if b.nextDelayMin >= b.nextDelayMax {
@@ -106,6 +164,48 @@ func (b *Backoff) NextDelay() time.Duration {
 	return sleepTime
 }
 
+// nextConstantDelay returns MinBackoff, shifted uniformly by up to Jitter in either
+// direction, without going below zero.
+func (b *Backoff) nextConstantDelay() time.Duration {
+	if b.cfg.Jitter <= 0 {
+		return b.cfg.MinBackoff
+	}
+
+	delay := b.cfg.MinBackoff + time.Duration(rand.Int63n(int64(2*b.cfg.Jitter)+1)) - b.cfg.Jitter
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
+// nextLinearDelay returns MinBackoff multiplied by the number of retries, capped at MaxBackoff.
+func (b *Backoff) nextLinearDelay() time.Duration {
+	if b.cfg.MinBackoff <= 0 || b.cfg.MinBackoff >= b.cfg.MaxBackoff {
+		return b.cfg.MinBackoff
+	}
+
+	// Avoid overflowing when the number of retries is large.
+	if int64(b.numRetries) > int64(b.cfg.MaxBackoff/b.cfg.MinBackoff) {
+		return b.cfg.MaxBackoff
+	}
+	return b.cfg.MinBackoff * time.Duration(b.numRetries)
+}
+
+type attemptContextKey struct{}
+
+// ContextWithAttempt returns a copy of ctx carrying the given attempt number,
+// so that downstream code (e.g. logging middleware) can tell retries apart.
+func ContextWithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptContextKey{}, attempt)
+}
+
+// AttemptFromContext returns the attempt number stored in ctx by ContextWithAttempt.
+// Attempts are numbered starting from 1.
+func AttemptFromContext(ctx context.Context) (int, bool) {
+	attempt, ok := ctx.Value(attemptContextKey{}).(int)
+	return attempt, ok
+}
+
 func doubleDuration(value time.Duration, max time.Duration) time.Duration {
 	value = value * 2
 
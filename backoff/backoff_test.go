@@ -2,8 +2,11 @@ package backoff
 
 import (
 	"context"
+	"math"
 	"testing"
 	"time"
+
+	grpcbackoff "google.golang.org/grpc/backoff"
 )
 
 func TestBackoff_NextDelay(t *testing.T) {
@@ -101,3 +104,199 @@ func TestBackoff_NextDelay(t *testing.T) {
 		})
 	}
 }
+
+func TestBackoff_NextDelayWithStrategy(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		strategy       string
+		minBackoff     time.Duration
+		maxBackoff     time.Duration
+		expectedDelays []time.Duration
+	}{
+		"constant backoff always returns min": {
+			strategy:   StrategyConstant,
+			minBackoff: 100 * time.Millisecond,
+			maxBackoff: 10 * time.Second,
+			expectedDelays: []time.Duration{
+				100 * time.Millisecond,
+				100 * time.Millisecond,
+				100 * time.Millisecond,
+			},
+		},
+		"linear backoff adds min on each retry up to max": {
+			strategy:   StrategyLinear,
+			minBackoff: 100 * time.Millisecond,
+			maxBackoff: 350 * time.Millisecond,
+			expectedDelays: []time.Duration{
+				100 * time.Millisecond,
+				200 * time.Millisecond,
+				300 * time.Millisecond,
+				350 * time.Millisecond,
+				350 * time.Millisecond,
+			},
+		},
+		"linear backoff with min greater than max": {
+			strategy:   StrategyLinear,
+			minBackoff: 200 * time.Millisecond,
+			maxBackoff: 100 * time.Millisecond,
+			expectedDelays: []time.Duration{
+				200 * time.Millisecond,
+				200 * time.Millisecond,
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		testData := testData
+
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			b := New(context.Background(), Config{
+				MinBackoff: testData.minBackoff,
+				MaxBackoff: testData.maxBackoff,
+				MaxRetries: len(testData.expectedDelays),
+				Strategy:   testData.strategy,
+			})
+
+			for _, expectedDelay := range testData.expectedDelays {
+				if delay := b.NextDelay(); delay != expectedDelay {
+					t.Errorf("%d expected to be %d", delay, expectedDelay)
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	for _, strategy := range []string{"", StrategyExponential, StrategyLinear, StrategyConstant, StrategyErrorAware} {
+		cfg := Config{Strategy: strategy}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("unexpected error for strategy %q: %v", strategy, err)
+		}
+	}
+
+	cfg := Config{Strategy: "fibonacci"}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unsupported strategy")
+	}
+
+	cfg = Config{Strategy: StrategyConstant, Jitter: -time.Second}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative jitter")
+	}
+}
+
+func TestConfig_ToGRPCBackoffConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		cfg      Config
+		expected grpcbackoff.Config
+	}{
+		"exponential": {
+			cfg:      Config{MinBackoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second, MaxRetries: 5},
+			expected: grpcbackoff.Config{BaseDelay: 100 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: 10 * time.Second},
+		},
+		"constant": {
+			cfg:      Config{MinBackoff: time.Second, MaxBackoff: 10 * time.Second, Strategy: StrategyConstant},
+			expected: grpcbackoff.Config{BaseDelay: time.Second, Multiplier: 1, Jitter: 0.2, MaxDelay: time.Second},
+		},
+	}
+
+	for name, test := range tests {
+		if actual := test.cfg.ToGRPCBackoffConfig(); actual != test.expected {
+			t.Errorf("%s: expected %+v, got %+v", name, test.expected, actual)
+		}
+	}
+}
+
+func TestBackoff_NextDelayWithJitteredConstantStrategy(t *testing.T) {
+	t.Parallel()
+
+	const (
+		minBackoff = time.Second
+		jitter     = 100 * time.Millisecond
+		samples    = 10000
+	)
+
+	b := New(context.Background(), Config{
+		MinBackoff: minBackoff,
+		MaxBackoff: time.Minute,
+		Strategy:   StrategyConstant,
+		Jitter:     jitter,
+	})
+
+	var sum time.Duration
+	min, max := time.Duration(math.MaxInt64), time.Duration(0)
+	for i := 0; i < samples; i++ {
+		delay := b.NextDelay()
+		if delay < minBackoff-jitter || delay > minBackoff+jitter {
+			t.Fatalf("delay %s out of the jitter band around %s", delay, minBackoff)
+		}
+		if delay < min {
+			min = delay
+		}
+		if delay > max {
+			max = delay
+		}
+		sum += delay
+	}
+
+	// The delays don't grow, and are spread across the band around MinBackoff.
+	if mean := sum / samples; mean < minBackoff-jitter/10 || mean > minBackoff+jitter/10 {
+		t.Errorf("expected the mean delay to be close to %s, got %s", minBackoff, mean)
+	}
+	if min > minBackoff-jitter*9/10 || max < minBackoff+jitter*9/10 {
+		t.Errorf("expected the delays to cover the jitter band, got min %s and max %s", min, max)
+	}
+
+	// The jitter doesn't make the delay negative.
+	b = New(context.Background(), Config{MinBackoff: jitter / 2, Strategy: StrategyConstant, Jitter: jitter})
+	for i := 0; i < 1000; i++ {
+		if delay := b.NextDelay(); delay < 0 {
+			t.Fatalf("unexpected negative delay %s", delay)
+		}
+	}
+}
+
+func TestBackoff_Clone(t *testing.T) {
+	t.Parallel()
+
+	b := New(context.Background(), Config{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Second,
+		MaxRetries: 3,
+		Strategy:   StrategyLinear,
+	})
+	b.NextDelay()
+	b.NextDelay()
+
+	c := b.Clone()
+	if c.NumRetries() != 0 {
+		t.Errorf("expected the clone to start with no retries, got %d", c.NumRetries())
+	}
+	if delay := c.NextDelay(); delay != time.Millisecond {
+		t.Errorf("expected the clone to start from the min backoff, got %s", delay)
+	}
+
+	// Advancing the clone doesn't affect the original backoff, and vice versa.
+	c.NextDelay()
+	c.NextDelay()
+	if b.NumRetries() != 2 {
+		t.Errorf("expected the original backoff to have 2 retries, got %d", b.NumRetries())
+	}
+	if !b.Ongoing() {
+		t.Error("expected the original backoff to be ongoing")
+	}
+	if c.Ongoing() {
+		t.Error("expected the clone to have exhausted its retries")
+	}
+	if delay := b.NextDelay(); delay != 3*time.Millisecond {
+		t.Errorf("expected the original backoff delay to be unaffected by the clone, got %s", delay)
+	}
+	if c.NumRetries() != 3 {
+		t.Errorf("expected the clone to have 3 retries, got %d", c.NumRetries())
+	}
+}
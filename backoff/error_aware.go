@@ -0,0 +1,79 @@
+package backoff
+
+import (
+	"context"
+	"time"
+)
+
+// errorStreakMultiplier is the factor by which the delay grows on each retry after an
+// error of the same class as the previous one.
+const errorStreakMultiplier = 4
+
+// ErrorAwareBackoff is a Backoff whose delay depends on the errors being retried, to
+// back off quickly from a dependency failing repeatedly the same way, while retrying
+// promptly when the failure changes (e.g. a flapping dependency). Use NextDelayFor and
+// WaitFor instead of NextDelay and Wait, which ignore the errors and behave like the
+// exponential strategy.
+type ErrorAwareBackoff struct {
+	*Backoff
+
+	classify  func(error) string
+	streak    bool // Whether lastClass and delay track a streak of errors.
+	lastClass string
+	delay     time.Duration
+}
+
+// NewErrorAware creates an ErrorAwareBackoff. Pass a Context that can also terminate
+// the operation. The errors are of the same class when classify returns the same value
+// for them, e.g. their gRPC status code. If classify is nil, the errors are of the same
+// class when they have the same message.
+func NewErrorAware(ctx context.Context, cfg Config, classify func(error) string) *ErrorAwareBackoff {
+	if classify == nil {
+		classify = error.Error
+	}
+	return &ErrorAwareBackoff{Backoff: New(ctx, cfg), classify: classify}
+}
+
+// Reset the ErrorAwareBackoff back to its initial condition.
+func (b *ErrorAwareBackoff) Reset() {
+	b.Backoff.Reset()
+	b.streak = false
+}
+
+// NextDelayFor increases the retry count and returns the delay before retrying after
+// err. The delay starts from MinBackoff and is multiplied by 4, up to MaxBackoff, each
+// time err is of the same class as the previous error. A different class resets the
+// delay to MinBackoff.
+func (b *ErrorAwareBackoff) NextDelayFor(err error) time.Duration {
+	b.numRetries++
+
+	class := b.classify(err)
+	if !b.streak || class != b.lastClass {
+		b.streak, b.lastClass, b.delay = true, class, b.cfg.MinBackoff
+		return b.delay
+	}
+
+	if b.delay >= b.cfg.MaxBackoff/errorStreakMultiplier {
+		b.delay = b.cfg.MaxBackoff
+	} else {
+		b.delay *= errorStreakMultiplier
+	}
+	if b.delay < b.cfg.MinBackoff {
+		// MaxBackoff is misconfigured lower than MinBackoff.
+		b.delay = b.cfg.MinBackoff
+	}
+	return b.delay
+}
+
+// WaitFor sleeps for the delay returned by NextDelayFor(err). Returns immediately if
+// the Context is terminated.
+func (b *ErrorAwareBackoff) WaitFor(err error) {
+	sleepTime := b.NextDelayFor(err)
+
+	if b.Ongoing() {
+		select {
+		case <-b.ctx.Done():
+		case <-time.After(sleepTime):
+		}
+	}
+}
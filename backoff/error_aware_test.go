@@ -0,0 +1,102 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorAwareBackoff_NextDelayFor(t *testing.T) {
+	t.Parallel()
+
+	var (
+		unavailable       = errors.New("unavailable: connection refused")
+		resourceExhausted = errors.New("resource exhausted: rate limited")
+		plain             = errors.New("plain error")
+	)
+	// The class of an error is its prefix, up to the first colon.
+	classify := func(err error) string {
+		return strings.SplitN(err.Error(), ":", 2)[0]
+	}
+
+	tests := map[string]struct {
+		errors         []error
+		expectedDelays []time.Duration
+	}{
+		"streak of the same code escalates quickly up to max": {
+			errors: []error{unavailable, unavailable, unavailable, unavailable, unavailable},
+			expectedDelays: []time.Duration{
+				10 * time.Millisecond,
+				40 * time.Millisecond,
+				160 * time.Millisecond,
+				500 * time.Millisecond,
+				500 * time.Millisecond,
+			},
+		},
+		"alternating classes always reset to min": {
+			errors: []error{unavailable, resourceExhausted, unavailable, resourceExhausted},
+			expectedDelays: []time.Duration{
+				10 * time.Millisecond,
+				10 * time.Millisecond,
+				10 * time.Millisecond,
+				10 * time.Millisecond,
+			},
+		},
+		"a new class resets the streak": {
+			errors: []error{unavailable, unavailable, plain, plain, unavailable},
+			expectedDelays: []time.Duration{
+				10 * time.Millisecond,
+				40 * time.Millisecond,
+				10 * time.Millisecond,
+				40 * time.Millisecond,
+				10 * time.Millisecond,
+			},
+		},
+	}
+
+	for testName, testData := range tests {
+		testData := testData
+
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+
+			b := NewErrorAware(context.Background(), Config{
+				MinBackoff: 10 * time.Millisecond,
+				MaxBackoff: 500 * time.Millisecond,
+				MaxRetries: len(testData.errors),
+				Strategy:   StrategyErrorAware,
+			}, classify)
+
+			for i, err := range testData.errors {
+				if delay := b.NextDelayFor(err); delay != testData.expectedDelays[i] {
+					t.Errorf("retry %d: %s expected to be %s", i, delay, testData.expectedDelays[i])
+				}
+			}
+			if b.NumRetries() != len(testData.errors) || b.Ongoing() {
+				t.Errorf("expected the backoff to have exhausted its %d retries, got %d", len(testData.errors), b.NumRetries())
+			}
+
+			b.Reset()
+			if delay := b.NextDelayFor(unavailable); delay != 10*time.Millisecond {
+				t.Errorf("%s expected to be the min backoff after reset", delay)
+			}
+		})
+	}
+}
+
+func TestErrorAwareBackoff_NextDelayForWithoutClassifier(t *testing.T) {
+	b := NewErrorAware(context.Background(), Config{MinBackoff: 10 * time.Millisecond, MaxBackoff: time.Second}, nil)
+
+	// The errors are of the same class when they have the same message.
+	for i, expected := range []time.Duration{10 * time.Millisecond, 40 * time.Millisecond, 10 * time.Millisecond} {
+		err := errors.New("failed")
+		if i == 2 {
+			err = errors.New("failed differently")
+		}
+		if delay := b.NextDelayFor(err); delay != expected {
+			t.Errorf("retry %d: %s expected to be %s", i, delay, expected)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package backoff
+
+import (
+	"fmt"
+	"time"
+)
+
+// Names of the backoff presets, as accepted by PresetByName.
+const (
+	PresetFast       = "fast"
+	PresetStandard   = "standard"
+	PresetAggressive = "aggressive"
+)
+
+// FastRetries returns a Config giving up quickly, suitable for latency-sensitive
+// operations where failing fast is preferable to waiting.
+func FastRetries() Config {
+	return Config{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 200 * time.Millisecond,
+		MaxRetries: 3,
+	}
+}
+
+// StandardRetries returns a Config matching the default values of the backoff flags.
+func StandardRetries() Config {
+	return Config{
+		MinBackoff: 100 * time.Millisecond,
+		MaxBackoff: 10 * time.Second,
+		MaxRetries: 10,
+	}
+}
+
+// AggressiveRetries returns a Config retrying many times with short delays, suitable
+// for operations which are expected to eventually succeed.
+func AggressiveRetries() Config {
+	return Config{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: time.Second,
+		MaxRetries: 20,
+	}
+}
+
+// PresetByName returns the preset Config with the given name.
+func PresetByName(name string) (Config, error) {
+	switch name {
+	case PresetFast:
+		return FastRetries(), nil
+	case PresetStandard:
+		return StandardRetries(), nil
+	case PresetAggressive:
+		return AggressiveRetries(), nil
+	default:
+		return Config{}, fmt.Errorf("unknown backoff preset: %s", name)
+	}
+}
@@ -0,0 +1,28 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPresetByName(t *testing.T) {
+	tests := map[string]Config{
+		PresetFast:       {MinBackoff: 10 * time.Millisecond, MaxBackoff: 200 * time.Millisecond, MaxRetries: 3},
+		PresetStandard:   {MinBackoff: 100 * time.Millisecond, MaxBackoff: 10 * time.Second, MaxRetries: 10},
+		PresetAggressive: {MinBackoff: 10 * time.Millisecond, MaxBackoff: time.Second, MaxRetries: 20},
+	}
+
+	for name, expected := range tests {
+		actual, err := PresetByName(name)
+		if err != nil {
+			t.Errorf("unexpected error for preset %q: %v", name, err)
+		}
+		if actual != expected {
+			t.Errorf("preset %q: expected %+v, got %+v", name, expected, actual)
+		}
+	}
+
+	if _, err := PresetByName("unknown"); err == nil || err.Error() != "unknown backoff preset: unknown" {
+		t.Errorf("unexpected error for unknown preset: %v", err)
+	}
+}
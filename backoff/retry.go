@@ -0,0 +1,51 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+)
+
+// Retry calls f until it succeeds, backing off according to cfg between attempts. f is
+// given ctx, carrying the attempt number (see AttemptFromContext). Retry stops as soon
+// as ctx is done, without waiting for the end of the current delay: called from the
+// goroutines of an errgroup.Group with the context returned by errgroup.WithContext, a
+// fatal error in one goroutine promptly stops the retries of the others.
+//
+// It returns nil once f succeeds, otherwise the last error returned by f, wrapped with
+// the reason for giving up: errors.Is matches both, e.g. context.Canceled when ctx is
+// canceled.
+func Retry(ctx context.Context, cfg Config, f func(ctx context.Context) error) error {
+	var err error
+	b := New(ctx, cfg)
+	for b.Ongoing() {
+		if err = f(ContextWithAttempt(ctx, b.NumRetries()+1)); err == nil {
+			return nil
+		}
+		b.Wait()
+	}
+	if err == nil {
+		return b.Err()
+	}
+	return &retryError{reason: b.Err(), err: err}
+}
+
+// retryError is the last error returned by the function given to Retry, along with the
+// reason for giving up.
+type retryError struct {
+	reason error
+	err    error
+}
+
+func (e *retryError) Error() string {
+	return e.reason.Error() + ": " + e.err.Error()
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the reason for giving up matches target, so that errors.Is matches
+// the context errors.
+func (e *retryError) Is(target error) bool {
+	return errors.Is(e.reason, target)
+}
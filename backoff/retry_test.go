@@ -0,0 +1,75 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func TestRetry(t *testing.T) {
+	errFailed := errors.New("failed")
+	cfg := Config{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 3}
+
+	var attempts []int
+	err := Retry(context.Background(), cfg, func(ctx context.Context) error {
+		attempt, _ := AttemptFromContext(ctx)
+		attempts = append(attempts, attempt)
+		if attempt < 2 {
+			return errFailed
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Errorf("unexpected attempts: %v", attempts)
+	}
+
+	err = Retry(context.Background(), cfg, func(ctx context.Context) error {
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) || err.Error() != "terminated after 3 retries: failed" {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRetryStopsWhenTheGroupIsCanceled(t *testing.T) {
+	errFatal := errors.New("fatal")
+	// The delays are long enough for the test to time out if the waits weren't interrupted.
+	cfg := Config{MinBackoff: time.Minute, MaxBackoff: time.Minute}
+
+	g, ctx := errgroup.WithContext(context.Background())
+	retryErrs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		g.Go(func() error {
+			err := Retry(ctx, cfg, func(context.Context) error {
+				return errors.New("not ready")
+			})
+			retryErrs <- err
+			return err
+		})
+	}
+	g.Go(func() error {
+		time.Sleep(50 * time.Millisecond)
+		return errFatal
+	})
+
+	start := time.Now()
+	if err := g.Wait(); err != errFatal {
+		t.Errorf("expected the fatal error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("the retries took %s to stop", elapsed)
+	}
+
+	close(retryErrs)
+	for err := range retryErrs {
+		if !errors.Is(err, context.Canceled) || err.Error() != "context canceled: not ready" {
+			t.Errorf("unexpected retry error: %v", err)
+		}
+	}
+}
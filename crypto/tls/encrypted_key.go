@@ -0,0 +1,28 @@
+package tls
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+
+	"github.com/pkg/errors"
+	"github.com/youmark/pkcs8"
+)
+
+// decryptPrivateKeyPEM decrypts the PKCS#8 "ENCRYPTED PRIVATE KEY" block in keyPEM,
+// returning an unencrypted "PRIVATE KEY" block.
+func decryptPrivateKeyPEM(keyPEM []byte, password string) ([]byte, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "ENCRYPTED PRIVATE KEY" {
+		return nil, errors.New("no PEM block of type ENCRYPTED PRIVATE KEY found")
+	}
+
+	key, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -1,14 +1,24 @@
 package tls
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/pem"
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"time"
 
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/pkcs12"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+
+	"github.com/grafana/dskit/flagext"
 )
 
 // ClientConfig is the config for client TLS.
@@ -18,70 +28,337 @@ type ClientConfig struct {
 	CAPath             string `yaml:"tls_ca_path"`
 	ServerName         string `yaml:"tls_server_name"`
 	InsecureSkipVerify bool   `yaml:"tls_insecure_skip_verify"`
+	ExpandEnvPaths     bool   `yaml:"tls_expand_env_paths"`
+
+	// IncludeSystemCAs validates the server certificate against the host's root CA
+	// certificates in addition to the configured CA certificates, which otherwise replace
+	// them, so that both public and private chains are accepted.
+	IncludeSystemCAs bool `yaml:"tls_include_system_cas"`
+
+	// InsecureSkipHostnameVerify validates the server certificate chain, but not that the
+	// certificate matches the server name. It has no effect with InsecureSkipVerify.
+	InsecureSkipHostnameVerify bool `yaml:"tls_insecure_skip_hostname_verify"`
+
+	// KeyPassword decrypts the key at KeyPath, when it's an encrypted PKCS#8 key.
+	KeyPassword flagext.Secret `yaml:"tls_key_password"`
+
+	// PKCS12File is a PKCS#12 bundle containing the client certificate and key, and
+	// optionally CA certificates. It can't be used together with CertPath and KeyPath.
+	PKCS12File     string         `yaml:"tls_pkcs12_file"`
+	PKCS12Password flagext.Secret `yaml:"tls_pkcs12_password"`
+
+	HandshakeTimeout time.Duration `yaml:"tls_handshake_timeout"`
+
+	// LogTLSDetails logs the TLS version and cipher suite negotiated by each connection,
+	// and the subject of the server certificate, to Logger, which must then be set.
+	LogTLSDetails bool       `yaml:"tls_log_details"`
+	Logger        log.Logger `yaml:"-"`
 }
 
 var (
-	errKeyMissing  = errors.New("certificate given but no key configured")
-	errCertMissing = errors.New("key given but no certificate configured")
+	errKeyMissing   = errors.New("certificate given but no key configured")
+	errCertMissing  = errors.New("key given but no certificate configured")
+	errPKCS12AndPEM = errors.New("PKCS#12 file can't be configured together with a certificate or key path")
+	errNoTLSLogger  = errors.New("TLS details logging is enabled but no logger is configured")
 )
 
 // RegisterFlagsWithPrefix registers flags with prefix.
 func (cfg *ClientConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.StringVar(&cfg.CertPath, prefix+".tls-cert-path", "", "Path to the client certificate file, which will be used for authenticating with the server. Also requires the key path to be configured.")
 	f.StringVar(&cfg.KeyPath, prefix+".tls-key-path", "", "Path to the key file for the client certificate. Also requires the client certificate to be configured.")
+	f.Var(&cfg.KeyPassword, prefix+".tls-key-password", "Password to decrypt the key file for the client certificate, when it's an encrypted PKCS#8 key.")
 	f.StringVar(&cfg.CAPath, prefix+".tls-ca-path", "", "Path to the CA certificates file to validate server certificate against. If not set, the host's root CA certificates are used.")
+	f.BoolVar(&cfg.IncludeSystemCAs, prefix+".tls-include-system-cas", false, "Validate the server certificate against the host's root CA certificates in addition to the configured CA certificates, instead of only the configured ones.")
 	f.StringVar(&cfg.ServerName, prefix+".tls-server-name", "", "Override the expected name on the server certificate.")
+	f.StringVar(&cfg.PKCS12File, prefix+".tls-pkcs12-file", "", "Path to a PKCS#12 bundle containing the client certificate and key, and optionally CA certificates to validate the server certificate against. Can't be used together with the certificate and key paths.")
+	f.Var(&cfg.PKCS12Password, prefix+".tls-pkcs12-password", "Password of the PKCS#12 bundle.")
 	f.BoolVar(&cfg.InsecureSkipVerify, prefix+".tls-insecure-skip-verify", false, "Skip validating server certificate.")
+	f.BoolVar(&cfg.InsecureSkipHostnameVerify, prefix+".tls-insecure-skip-hostname-verify", false, "Skip validating that the server certificate matches the server name, while still validating its chain against the CA certificates.")
+	f.BoolVar(&cfg.LogTLSDetails, prefix+".tls-log-details", false, "Log the TLS version and cipher suite negotiated by each connection, and the subject of the server certificate, at info level. Requires the application to provide a logger.")
+	f.DurationVar(&cfg.HandshakeTimeout, prefix+".tls-handshake-timeout", 0, "Maximum time to wait for the TLS handshake to complete once connected. 0 means no timeout other than the dial one.")
+	f.BoolVar(&cfg.ExpandEnvPaths, prefix+".tls-expand-env-paths", false, "Expand environment variables (e.g. $CERT_DIR) in the certificate, key and CA paths. Undefined variables are replaced by the empty string.")
+}
+
+// Validate the config.
+func (cfg *ClientConfig) Validate() error {
+	if cfg.PKCS12File != "" && (cfg.CertPath != "" || cfg.KeyPath != "") {
+		return errPKCS12AndPEM
+	}
+	if cfg.LogTLSDetails && cfg.Logger == nil {
+		return errNoTLSLogger
+	}
+	return nil
 }
 
 // GetTLSConfig initialises tls.Config from config options
 func (cfg *ClientConfig) GetTLSConfig() (*tls.Config, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
 	config := &tls.Config{
 		InsecureSkipVerify: cfg.InsecureSkipVerify,
 		ServerName:         cfg.ServerName,
 	}
 
+	certPath, keyPath, caPath, pkcs12Path := cfg.CertPath, cfg.KeyPath, cfg.CAPath, cfg.PKCS12File
+	if cfg.ExpandEnvPaths {
+		certPath, keyPath, caPath, pkcs12Path = os.ExpandEnv(certPath), os.ExpandEnv(keyPath), os.ExpandEnv(caPath), os.ExpandEnv(pkcs12Path)
+	}
+
 	// read ca certificates
-	if cfg.CAPath != "" {
-		var caCertPool *x509.CertPool
-		caCert, err := os.ReadFile(cfg.CAPath)
+	if caPath != "" {
+		caCert, err := os.ReadFile(caPath)
 		if err != nil {
-			return nil, errors.Wrapf(err, "error loading ca cert: %s", cfg.CAPath)
+			return nil, errors.Wrapf(err, "error loading ca cert: %s", caPath)
+		}
+		caCertPool, err := cfg.newCertPool()
+		if err != nil {
+			return nil, err
+		}
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.Errorf("error parsing ca cert: no valid PEM certificate found in %s", caPath)
 		}
-		caCertPool = x509.NewCertPool()
-		caCertPool.AppendCertsFromPEM(caCert)
 
 		config.RootCAs = caCertPool
 	}
 
 	// read client certificate
-	if cfg.CertPath != "" || cfg.KeyPath != "" {
-		if cfg.CertPath == "" {
+	if certPath != "" || keyPath != "" {
+		if certPath == "" {
 			return nil, errCertMissing
 		}
-		if cfg.KeyPath == "" {
+		if keyPath == "" {
 			return nil, errKeyMissing
 		}
-		clientCert, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+		certPEM, err := os.ReadFile(certPath)
 		if err != nil {
-			return nil, errors.Wrapf(err, "failed to load TLS certificate %s,%s", cfg.CertPath, cfg.KeyPath)
+			return nil, errors.Wrapf(err, "error loading client cert: %s", certPath)
+		}
+		keyPEM, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading client key: %s", keyPath)
+		}
+		if cfg.KeyPassword.Value != "" {
+			keyPEM, err = decryptPrivateKeyPEM(keyPEM, cfg.KeyPassword.Value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error decrypting client key: %s", keyPath)
+			}
+		}
+		clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to load TLS certificate %s,%s", certPath, keyPath)
 		}
 		config.Certificates = []tls.Certificate{clientCert}
 	}
 
+	// read PKCS#12 bundle
+	if pkcs12Path != "" {
+		clientCert, caCerts, err := loadPKCS12(pkcs12Path, cfg.PKCS12Password.Value)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{clientCert}
+
+		if len(caCerts) > 0 && config.RootCAs == nil {
+			config.RootCAs, err = cfg.newCertPool()
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, caCert := range caCerts {
+			config.RootCAs.AddCert(caCert)
+		}
+	}
+
+	if cfg.InsecureSkipHostnameVerify && !cfg.InsecureSkipVerify {
+		// The default verification always checks the server name: replace it.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = verifyChain(config.RootCAs)
+	}
+
+	if cfg.LogTLSDetails {
+		config.VerifyConnection = logConnection(cfg.Logger, config.VerifyConnection)
+	}
+
 	return config, nil
 }
 
+// systemCertPool is replaced in tests.
+var systemCertPool = x509.SystemCertPool
+
+// newCertPool returns the pool to add the configured CA certificates to: a copy of the
+// host's root CA certificates if IncludeSystemCAs is set, otherwise an empty pool.
+func (cfg *ClientConfig) newCertPool() (*x509.CertPool, error) {
+	if !cfg.IncludeSystemCAs {
+		return x509.NewCertPool(), nil
+	}
+	pool, err := systemCertPool()
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading system ca certs")
+	}
+	return pool, nil
+}
+
+// verifyChain returns a tls.Config VerifyConnection callback validating the server
+// certificate chain against roots, or the host's root CAs if nil, without checking the
+// server name.
+func verifyChain(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server didn't present a certificate")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// logConnection returns a tls.Config VerifyConnection callback logging the details of the
+// connection once verify, if set, has accepted it.
+func logConnection(logger log.Logger, verify func(tls.ConnectionState) error) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+
+		subject := ""
+		if len(cs.PeerCertificates) > 0 {
+			subject = cs.PeerCertificates[0].Subject.String()
+		}
+		level.Info(logger).Log("msg", "TLS connection established", "server_name", cs.ServerName, "version", tlsVersionName(cs.Version), "cipher_suite", tls.CipherSuiteName(cs.CipherSuite), "peer_subject", subject)
+		return nil
+	}
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
+}
+
+// loadPKCS12 decodes the PKCS#12 bundle at path, returning the client certificate and
+// the CA certificates it contains.
+func loadPKCS12(path, password string) (tls.Certificate, []*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.Wrapf(err, "error loading PKCS#12 file: %s", path)
+	}
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.Wrapf(err, "error decoding PKCS#12 file: %s", path)
+	}
+
+	var keyBlock *pem.Block
+	var certBlocks []*pem.Block
+	for _, block := range blocks {
+		switch block.Type {
+		case "PRIVATE KEY":
+			keyBlock = block
+		case "CERTIFICATE":
+			certBlocks = append(certBlocks, block)
+		}
+	}
+	if keyBlock == nil || len(certBlocks) == 0 {
+		return tls.Certificate{}, nil, errors.Errorf("error decoding PKCS#12 file: no certificate and key found in %s", path)
+	}
+
+	// The client certificate shares the local key ID of the key, the other ones are CAs.
+	leaf := 0
+	for i, block := range certBlocks {
+		if id, ok := block.Headers["localKeyId"]; ok && id == keyBlock.Headers["localKeyId"] {
+			leaf = i
+			break
+		}
+	}
+
+	clientCert, err := tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: certBlocks[leaf].Type, Bytes: certBlocks[leaf].Bytes}),
+		pem.EncodeToMemory(&pem.Block{Type: keyBlock.Type, Bytes: keyBlock.Bytes}),
+	)
+	if err != nil {
+		return tls.Certificate{}, nil, errors.Wrapf(err, "failed to load TLS certificate from PKCS#12 file %s", path)
+	}
+
+	var caCerts []*x509.Certificate
+	for i, block := range certBlocks {
+		if i == leaf {
+			continue
+		}
+		caCert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return tls.Certificate{}, nil, errors.Wrapf(err, "error parsing ca cert from PKCS#12 file %s", path)
+		}
+		caCerts = append(caCerts, caCert)
+	}
+	return clientCert, caCerts, nil
+}
+
 // GetGRPCDialOptions creates GRPC DialOptions for TLS
 func (cfg *ClientConfig) GetGRPCDialOptions(enabled bool) ([]grpc.DialOption, error) {
 	if !enabled {
 		return []grpc.DialOption{grpc.WithInsecure()}, nil
 	}
 
-	tlsConfig, err := cfg.GetTLSConfig()
+	creds, err := cfg.GetGRPCTransportCredentials()
 	if err != nil {
 		return nil, errors.Wrap(err, "error creating grpc dial options")
 	}
 
-	return []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))}, nil
+	return []grpc.DialOption{grpc.WithTransportCredentials(creds)}, nil
+}
+
+// GetGRPCTransportCredentials creates GRPC TransportCredentials for TLS
+func (cfg *ClientConfig) GetGRPCTransportCredentials() (credentials.TransportCredentials, error) {
+	tlsConfig, err := cfg.GetTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.transportCredentials(tlsConfig), nil
+}
+
+func (cfg *ClientConfig) transportCredentials(tlsConfig *tls.Config) credentials.TransportCredentials {
+	creds := credentials.NewTLS(tlsConfig)
+	if cfg.HandshakeTimeout > 0 {
+		return &handshakeTimeoutCredentials{TransportCredentials: creds, timeout: cfg.HandshakeTimeout}
+	}
+	return creds
+}
+
+// handshakeTimeoutCredentials bounds the duration of the client TLS handshake.
+type handshakeTimeoutCredentials struct {
+	credentials.TransportCredentials
+	timeout time.Duration
+}
+
+func (c *handshakeTimeoutCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	handshakeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	conn, authInfo, err := c.TransportCredentials.ClientHandshake(handshakeCtx, authority, rawConn)
+	if err != nil && handshakeCtx.Err() != nil && ctx.Err() == nil {
+		return nil, nil, errors.Wrapf(err, "TLS handshake timed out after %s", c.timeout)
+	}
+	return conn, authInfo, err
+}
+
+func (c *handshakeTimeoutCredentials) Clone() credentials.TransportCredentials {
+	return &handshakeTimeoutCredentials{TransportCredentials: c.TransportCredentials.Clone(), timeout: c.timeout}
 }
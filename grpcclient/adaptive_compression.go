@@ -0,0 +1,120 @@
+package grpcclient
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/encoding/proto"
+
+	"github.com/grafana/dskit/grpcencoding/snappy"
+)
+
+const (
+	// Number of requests per method used to measure the compression ratio.
+	adaptiveCompressionSamples = 10
+
+	// Compression is only used if it shrinks payloads by at least this fraction.
+	adaptiveCompressionMinSaving = 0.1
+
+	// gzip is only preferred over the cheaper snappy if its output is at least
+	// this fraction smaller than snappy's.
+	adaptiveCompressionGzipAdvantage = 0.2
+)
+
+// NewAdaptiveCompression creates a UnaryClientInterceptor which picks the compressor
+// to use for each method based on how well its payloads compress. The first requests
+// of each method are sent using the configured compression, while their payloads are
+// compressed with both gzip and snappy to measure the compression ratio. Once enough
+// samples have been collected, subsequent requests of that method use snappy, gzip if
+// it does significantly better than snappy, or no compression at all if neither
+// makes payloads noticeably smaller.
+func NewAdaptiveCompression() grpc.UnaryClientInterceptor {
+	methods := sync.Map{}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		stats, _ := methods.LoadOrStore(method, &methodCompressionStats{})
+
+		if compressor, ok := stats.(*methodCompressionStats).observe(req); ok {
+			// Copy the options to not modify the caller's slice.
+			opts = append(opts[:len(opts):len(opts)], grpc.UseCompressor(compressor))
+		}
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+type methodCompressionStats struct {
+	mu            sync.Mutex
+	samples       int
+	originalBytes int
+	gzipBytes     int
+	snappyBytes   int
+
+	decided    bool
+	compressor string
+}
+
+// observe returns the compressor chosen for the method, if any. While the compressor
+// hasn't been chosen yet, req is used as a sample.
+func (s *methodCompressionStats) observe(req interface{}) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.decided {
+		return s.compressor, true
+	}
+
+	data, err := encoding.GetCodec(proto.Name).Marshal(req)
+	if err != nil {
+		// Not a message we know how to measure: leave the configured compression.
+		return "", false
+	}
+
+	s.samples++
+	s.originalBytes += len(data)
+	s.gzipBytes += compressedSize(gzip.Name, data)
+	s.snappyBytes += compressedSize(snappy.Name, data)
+
+	if s.samples >= adaptiveCompressionSamples {
+		s.decided = true
+		s.compressor = s.choose()
+	}
+	return "", false
+}
+
+func (s *methodCompressionStats) choose() string {
+	compressor, compressedBytes := snappy.Name, s.snappyBytes
+	if float64(s.gzipBytes) <= float64(s.snappyBytes)*(1-adaptiveCompressionGzipAdvantage) {
+		compressor, compressedBytes = gzip.Name, s.gzipBytes
+	}
+
+	if float64(compressedBytes) > float64(s.originalBytes)*(1-adaptiveCompressionMinSaving) {
+		return ""
+	}
+	return compressor
+}
+
+// compressedSize returns the size of data once compressed with the named compressor.
+func compressedSize(name string, data []byte) int {
+	c := encoding.GetCompressor(name)
+	if c == nil {
+		return len(data)
+	}
+
+	buf := bytes.Buffer{}
+	w, err := c.Compress(&buf)
+	if err != nil {
+		return len(data)
+	}
+	if _, err := w.Write(data); err != nil {
+		return len(data)
+	}
+	if err := w.Close(); err != nil {
+		return len(data)
+	}
+	return buf.Len()
+}
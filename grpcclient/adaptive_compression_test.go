@@ -0,0 +1,97 @@
+package grpcclient_test
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/weaveworks/common/httpgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
+
+	"github.com/grafana/dskit/grpcclient"
+	"github.com/grafana/dskit/grpcencoding/snappy"
+)
+
+func TestAdaptiveCompression(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(0)).Read(random)
+
+	tests := map[string]struct {
+		payload            []byte
+		expectedCompressor string
+	}{
+		"payload made of repeated binary blocks": {
+			// Both compressors find the repetitions, but gzip can't do much better than
+			// snappy on the (random) content of each block.
+			payload:            bytes.Repeat(random[:1024], 4),
+			expectedCompressor: snappy.Name,
+		},
+		"text payload": {
+			payload:            []byte(strings.Repeat("the quick brown fox jumps over the lazy dog. ", 50) + strings.Repeat("pack my box with five dozen liquor jugs! ", 50)),
+			expectedCompressor: gzip.Name,
+		},
+		"incompressible payload": {
+			payload:            random,
+			expectedCompressor: "",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			var compressors []*grpc.CompressorCallOption
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				var compressor *grpc.CompressorCallOption
+				for _, opt := range opts {
+					if c, ok := opt.(grpc.CompressorCallOption); ok {
+						compressor = &c
+					}
+				}
+				compressors = append(compressors, compressor)
+				return nil
+			}
+
+			interceptor := grpcclient.NewAdaptiveCompression()
+			for i := 0; i < 15; i++ {
+				req := &httpgrpc.HTTPRequest{Body: testData.payload}
+				require.NoError(t, interceptor(context.Background(), "/test/method", req, nil, &grpc.ClientConn{}, invoker))
+			}
+
+			// While sampling, the configured compression is left untouched.
+			for _, c := range compressors[:10] {
+				assert.Nil(t, c)
+			}
+			for _, c := range compressors[10:] {
+				require.NotNil(t, c)
+				assert.Equal(t, testData.expectedCompressor, c.CompressorType)
+			}
+		})
+	}
+}
+
+func TestAdaptiveCompressionTracksMethodsIndependently(t *testing.T) {
+	chosen := map[string]string{}
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		for _, opt := range opts {
+			if c, ok := opt.(grpc.CompressorCallOption); ok {
+				chosen[method] = c.CompressorType
+			}
+		}
+		return nil
+	}
+
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(0)).Read(random)
+
+	interceptor := grpcclient.NewAdaptiveCompression()
+	for i := 0; i < 11; i++ {
+		require.NoError(t, interceptor(context.Background(), "/test/compressible", &httpgrpc.HTTPRequest{Body: []byte(strings.Repeat("a", 4096))}, nil, &grpc.ClientConn{}, invoker))
+		require.NoError(t, interceptor(context.Background(), "/test/incompressible", &httpgrpc.HTTPRequest{Body: random}, nil, &grpc.ClientConn{}, invoker))
+	}
+
+	assert.Equal(t, map[string]string{"/test/compressible": gzip.Name, "/test/incompressible": ""}, chosen)
+}
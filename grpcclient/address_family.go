@@ -0,0 +1,20 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+)
+
+const (
+	addressFamilyIPv4 = "tcp4"
+	addressFamilyIPv6 = "tcp6"
+)
+
+// newAddressFamilyDialer returns a dialer connecting to addresses with the given network
+// only, e.g. "tcp4", so that host names are resolved to addresses of that family.
+func newAddressFamilyDialer(network string) func(context.Context, string) (net.Conn, error) {
+	var dialer net.Dialer
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
@@ -0,0 +1,53 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddressFamilyDialer(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	addr := listener.Addr().String()
+
+	conn, err := newAddressFamilyDialer(addressFamilyIPv4)(context.Background(), addr)
+	require.NoError(t, err)
+	assert.NotNil(t, conn.LocalAddr().(*net.TCPAddr).IP.To4())
+	require.NoError(t, conn.Close())
+
+	// An IPv4 address can't be dialed with IPv6.
+	_, err = newAddressFamilyDialer(addressFamilyIPv6)(context.Background(), addr)
+	require.Error(t, err)
+}
+
+func TestConfigValidateAddressFamily(t *testing.T) {
+	for family, valid := range map[string]bool{
+		"":     true,
+		"tcp4": true,
+		"tcp6": true,
+		"tcp":  false,
+		"udp":  false,
+	} {
+		cfg := Config{AddressFamily: family}
+		err := cfg.Validate(nil)
+		if valid {
+			assert.NoError(t, err, family)
+		} else {
+			assert.EqualError(t, err, "unsupported address family: "+family)
+		}
+	}
+}
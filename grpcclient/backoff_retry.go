@@ -2,7 +2,15 @@ package grpcclient
 
 import (
 	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
 
+	"github.com/opentracing/opentracing-go"
+	otlog "github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -10,22 +18,258 @@ import (
 	"github.com/grafana/dskit/backoff"
 )
 
-// NewBackoffRetry gRPC middleware.
+// backoffRetriedKey marks the calls being retried on the errors with the given code.
+type backoffRetriedKey struct {
+	code codes.Code
+}
+
+type retriedCallKey struct{}
+
+// retriedCall holds the state shared by all the attempts of a call retried by the
+// retry interceptors, which the interceptors further down the chain can use to behave
+// the same way on every attempt.
+type retriedCall struct {
+	// idempotencyKey is the key set by NewIdempotencyKey on the first attempt.
+	idempotencyKey string
+}
+
+// NewBackoffRetry gRPC middleware. The current attempt number is injected into the
+// context passed to the invoker and can be read with backoff.AttemptFromContext.
+// If a retry interceptor created by this function is already handling the call
+// further up the interceptor chain, the call is passed through without retrying
+// again, so that retries don't multiply.
+//
+// Each call gets its own backoff, starting from cfg.MinBackoff. Every delay is randomly
+// shifted by up to 10%, so that many clients being rate limited at the same time
+// spread their retries. With the backoff.StrategyErrorAware strategy, the delay
+// escalates faster on consecutive rate limited attempts, see backoff.ErrorAwareBackoff.
+//
+// If the call is traced, an event recording the attempt, delay and error code is added
+// to the span before each wait.
+//
+// Use NewBackoffRetryWithRegisterer to track the retries in metrics.
 func NewBackoffRetry(cfg backoff.Config) grpc.UnaryClientInterceptor {
+	return newBackoffRetry(cfg, nil, nil)
+}
+
+// NewBackoffRetryWithRegisterer works like NewBackoffRetry, and if reg is not nil, the
+// number of retries and of attempts per call are tracked by the
+// grpc_client_backoff_retries_total and grpc_client_backoff_attempts_per_call metrics.
+// Interceptors created with the same registerer share them.
+func NewBackoffRetryWithRegisterer(cfg backoff.Config, reg prometheus.Registerer) grpc.UnaryClientInterceptor {
+	return newBackoffRetry(cfg, nil, newBackoffRetryMetrics(reg))
+}
+
+// NewSharedBackoffRetry works like NewBackoffRetry, but the backoff delay is shared by all
+// the calls going through the returned interceptor: a retry escalates the delay for the
+// following calls too, until any call succeeds, which resets the delay back to
+// cfg.MinBackoff. The cfg.MaxRetries limit still applies to each call separately.
+func NewSharedBackoffRetry(cfg backoff.Config) grpc.UnaryClientInterceptor {
+	return newBackoffRetry(cfg, newSharedBackoff(cfg), nil)
+}
+
+// NewSharedBackoffRetryWithRegisterer works like NewSharedBackoffRetry, tracking the
+// retries in metrics as NewBackoffRetryWithRegisterer does.
+func NewSharedBackoffRetryWithRegisterer(cfg backoff.Config, reg prometheus.Registerer) grpc.UnaryClientInterceptor {
+	return newBackoffRetry(cfg, newSharedBackoff(cfg), newBackoffRetryMetrics(reg))
+}
+
+func newBackoffRetry(cfg backoff.Config, shared *sharedBackoff, metrics *backoffRetryMetrics) grpc.UnaryClientInterceptor {
+	return newRetry(codes.ResourceExhausted, nil, cfg, shared, metrics)
+}
+
+// newRetry returns an interceptor retrying the calls failing with code, calling
+// beforeRetry, if not nil, before each retry. If another interceptor returned by
+// newRetry for the same code is already handling the call further up the chain, the
+// call is passed through.
+func newRetry(code codes.Code, beforeRetry func(cc *grpc.ClientConn), cfg backoff.Config, shared *sharedBackoff, metrics *backoffRetryMetrics) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		backoff := backoff.New(ctx, cfg)
-		for backoff.Ongoing() {
-			err := invoker(ctx, method, req, reply, cc, opts...)
+		if ctx.Value(backoffRetriedKey{code: code}) != nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx = context.WithValue(ctx, backoffRetriedKey{code: code}, struct{}{})
+		if _, ok := ctx.Value(retriedCallKey{}).(*retriedCall); !ok {
+			ctx = context.WithValue(ctx, retriedCallKey{}, &retriedCall{})
+		}
+
+		attempts := 0
+		defer func() {
+			metrics.observeAttempts(attempts)
+		}()
+
+		var lastErr error
+		b := backoff.NewErrorAware(ctx, cfg, statusCodeClass)
+		for b.Ongoing() {
+			attempts++
+			attemptCtx := backoff.ContextWithAttempt(ctx, b.NumRetries()+1)
+			err := invoker(attemptCtx, method, req, reply, cc, opts...)
+			lastErr = err
 			if err == nil {
+				if shared != nil {
+					shared.reset()
+				}
 				return nil
 			}
 
-			if status.Code(err) != codes.ResourceExhausted {
+			if status.Code(err) != code {
 				return err
 			}
 
-			backoff.Wait()
+			// The per-call backoff always keeps track of the number of retries.
+			var delay time.Duration
+			if cfg.Strategy == backoff.StrategyErrorAware {
+				delay = b.NextDelayFor(err)
+			} else {
+				delay = b.NextDelay()
+			}
+			if shared != nil {
+				delay = shared.nextDelay()
+			}
+			if b.Ongoing() {
+				metrics.observeRetry(method)
+				delay = jitterDelay(delay)
+				logRetry(ctx, b.NumRetries()+1, delay, err)
+				if beforeRetry != nil {
+					beforeRetry(cc)
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(delay):
+				}
+			}
+		}
+		if lastErr == nil {
+			return b.Err()
 		}
-		return backoff.Err()
+		return &retriesExhaustedError{reason: b.Err(), err: lastErr}
 	}
 }
+
+// retriesExhaustedError is the error of the last attempt of a call which ran out of
+// retries, along with the reason for giving up. It keeps the gRPC status code of the
+// last attempt, so that the interceptors further up the chain, e.g. the circuit breaker,
+// see why the call failed.
+type retriesExhaustedError struct {
+	reason error
+	err    error
+}
+
+func (e *retriesExhaustedError) Error() string {
+	return e.reason.Error() + ": " + e.err.Error()
+}
+
+func (e *retriesExhaustedError) Unwrap() error {
+	return e.err
+}
+
+// Is reports whether the reason for giving up matches target, so that errors.Is matches
+// the context errors.
+func (e *retriesExhaustedError) Is(target error) bool {
+	return errors.Is(e.reason, target)
+}
+
+func (e *retriesExhaustedError) GRPCStatus() *status.Status {
+	s := status.Convert(e.err).Proto()
+	s.Message = e.reason.Error() + ": " + s.Message
+	return status.FromProto(s)
+}
+
+// logRetry adds an event to the span of ctx, if any, recording that the given attempt is
+// made after waiting for delay because of err.
+func logRetry(ctx context.Context, attempt int, delay time.Duration, err error) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	span.LogFields(
+		otlog.String("event", "retry"),
+		otlog.Int("retry.attempt", attempt),
+		otlog.String("delay", delay.String()),
+		otlog.String("code", status.Code(err).String()),
+	)
+}
+
+type backoffRetryMetrics struct {
+	retries  *prometheus.CounterVec
+	attempts prometheus.Histogram
+}
+
+// newBackoffRetryMetrics returns nil, which tracks nothing, if reg is nil.
+func newBackoffRetryMetrics(reg prometheus.Registerer) *backoffRetryMetrics {
+	return newRetryMetrics(reg, "backoff", "rate limiting")
+}
+
+// newRetryMetrics returns the grpc_client_<kind>_retries_total and
+// grpc_client_<kind>_attempts_per_call metrics of the calls retried because of reason,
+// or nil if reg is nil.
+func newRetryMetrics(reg prometheus.Registerer, kind, reason string) *backoffRetryMetrics {
+	if reg == nil {
+		return nil
+	}
+
+	retries := promauto.With(nil).NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_client_" + kind + "_retries_total",
+		Help: "Total number of calls retried after backing off because of " + reason + ".",
+	}, []string{"method"})
+	attempts := promauto.With(nil).NewHistogram(prometheus.HistogramOpts{
+		Name:    "grpc_client_" + kind + "_attempts_per_call",
+		Help:    "Number of attempts per call, including retries after backing off because of " + reason + ".",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 6),
+	})
+	return &backoffRetryMetrics{
+		retries:  registerOrExisting(reg, retries).(*prometheus.CounterVec),
+		attempts: registerOrExisting(reg, attempts).(prometheus.Histogram),
+	}
+}
+
+func (m *backoffRetryMetrics) observeRetry(method string) {
+	if m != nil {
+		m.retries.WithLabelValues(method).Inc()
+	}
+}
+
+func (m *backoffRetryMetrics) observeAttempts(attempts int) {
+	if m != nil {
+		m.attempts.Observe(float64(attempts))
+	}
+}
+
+// retryJitter is the maximum fraction by which retry delays are randomly shifted.
+const retryJitter = 0.1
+
+// jitterDelay returns d randomly shifted by up to retryJitter of its value. It relies on
+// the top-level math/rand functions, which are safe for concurrent use.
+func jitterDelay(d time.Duration) time.Duration {
+	if d <= 0 {
+		return d
+	}
+	return d + time.Duration((rand.Float64()*2-1)*retryJitter*float64(d))
+}
+
+// sharedBackoff is a backoff.Backoff safe for concurrent use, used only to compute delays.
+type sharedBackoff struct {
+	mu      sync.Mutex
+	backoff *backoff.Backoff
+}
+
+func newSharedBackoff(cfg backoff.Config) *sharedBackoff {
+	return &sharedBackoff{backoff: backoff.New(context.Background(), cfg)}
+}
+
+func (s *sharedBackoff) nextDelay() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.backoff.NextDelay()
+}
+
+func (s *sharedBackoff) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backoff.Reset()
+}
+
+// statusCodeClass classifies the errors by gRPC status code, so that the error-aware
+// backoff escalates on streaks of errors with the same code.
+func statusCodeClass(err error) string {
+	return status.Code(err).String()
+}
@@ -0,0 +1,44 @@
+package grpcclient
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitterDelay(t *testing.T) {
+	const (
+		delay   = 100 * time.Millisecond
+		samples = 10000
+	)
+
+	var sum, sumSquares float64
+	min, max := time.Duration(math.MaxInt64), time.Duration(0)
+	for i := 0; i < samples; i++ {
+		d := jitterDelay(delay)
+		assert.GreaterOrEqual(t, int64(d), int64(90*time.Millisecond))
+		assert.LessOrEqual(t, int64(d), int64(110*time.Millisecond))
+
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+		sum += float64(d)
+		sumSquares += float64(d) * float64(d)
+	}
+
+	// Delays are uniformly distributed within ±10%: the mean is the original delay,
+	// and the standard deviation is 20% / sqrt(12) (~5.8%) of it.
+	mean := sum / samples
+	stddev := math.Sqrt(sumSquares/samples - mean*mean)
+	assert.InDelta(t, float64(delay), mean, float64(delay)*0.01)
+	assert.InDelta(t, float64(delay)*0.2/math.Sqrt(12), stddev, float64(delay)*0.01)
+	assert.Less(t, int64(min), int64(92*time.Millisecond))
+	assert.Greater(t, int64(max), int64(108*time.Millisecond))
+
+	assert.Equal(t, time.Duration(0), jitterDelay(0))
+}
@@ -0,0 +1,217 @@
+package grpcclient_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestBackoffRetryInjectsAttemptIntoContext(t *testing.T) {
+	var attempts []int
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempt, ok := backoff.AttemptFromContext(ctx)
+		require.True(t, ok)
+		attempts = append(attempts, attempt)
+		if len(attempts) < 3 {
+			return status.Error(codes.ResourceExhausted, "slow down")
+		}
+		return nil
+	}
+
+	retry := grpcclient.NewBackoffRetry(backoff.Config{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 5,
+	})
+	err := retry(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+}
+
+func TestBackoffRetryLogsRetriesToSpan(t *testing.T) {
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		if calls < 3 {
+			return status.Error(codes.ResourceExhausted, "slow down")
+		}
+		return nil
+	}
+
+	tracer := mocktracer.New()
+	span := tracer.StartSpan("test")
+	ctx := opentracing.ContextWithSpan(context.Background(), span)
+
+	retry := grpcclient.NewBackoffRetry(backoff.Config{
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+		MaxRetries: 5,
+	})
+	require.NoError(t, retry(ctx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker))
+	span.Finish()
+
+	logs := tracer.FinishedSpans()[0].Logs()
+	require.Len(t, logs, 2)
+	for i, record := range logs {
+		fields := map[string]string{}
+		for _, field := range record.Fields {
+			fields[field.Key] = field.ValueString
+		}
+		assert.Equal(t, "retry", fields["event"])
+		assert.Equal(t, fmt.Sprint(i+2), fields["retry.attempt"])
+		assert.Equal(t, "ResourceExhausted", fields["code"])
+
+		// The delay is jittered by up to 10%.
+		delay, err := time.ParseDuration(fields["delay"])
+		require.NoError(t, err)
+		assert.InDelta(t, 10*time.Millisecond, delay, float64(time.Millisecond))
+	}
+}
+
+func TestBackoffRetryIsNotAppliedTwiceInTheSameChain(t *testing.T) {
+	calls := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.ResourceExhausted, "slow down")
+	}
+
+	cfg := backoff.Config{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 2,
+	}
+	chain := middleware.ChainUnaryClient(grpcclient.NewBackoffRetry(cfg), grpcclient.NewBackoffRetry(cfg))
+
+	err := chain(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker)
+	require.Error(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestBackoffRetryResetOnSuccess(t *testing.T) {
+	const minBackoff = 50 * time.Millisecond
+
+	cfg := backoff.Config{
+		MinBackoff: minBackoff,
+		MaxBackoff: 16 * minBackoff,
+		MaxRetries: 10,
+	}
+
+	// failingInvoker returns ResourceExhausted for the given number of attempts, then
+	// returns finalErr.
+	failingInvoker := func(failures int, finalErr error) grpc.UnaryInvoker {
+		calls := 0
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			calls++
+			if calls <= failures {
+				return status.Error(codes.ResourceExhausted, "slow down")
+			}
+			return finalErr
+		}
+	}
+
+	// timedCall returns how long a call which is rate limited once before succeeding takes.
+	timedCall := func(t *testing.T, retry grpc.UnaryClientInterceptor) time.Duration {
+		start := time.Now()
+		require.NoError(t, retry(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, failingInvoker(1, nil)))
+		return time.Since(start)
+	}
+
+	t.Run("per-call backoff starts from min backoff on every call", func(t *testing.T) {
+		retry := grpcclient.NewBackoffRetry(cfg)
+
+		// Escalate the backoff and fail with a non retryable error.
+		err := retry(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, failingInvoker(3, status.Error(codes.Internal, "boom")))
+		require.Equal(t, codes.Internal, status.Code(err))
+
+		assert.Less(t, int64(timedCall(t, retry)), int64(4*minBackoff))
+	})
+
+	t.Run("error-aware backoff escalates faster on consecutive rate limits", func(t *testing.T) {
+		cfg := cfg
+		cfg.Strategy = backoff.StrategyErrorAware
+		retry := grpcclient.NewBackoffRetry(cfg)
+
+		// The delays are 1, 4 and 16 times the min backoff, minus the jitter. An exponential
+		// backoff would wait less than 14 times the min backoff.
+		start := time.Now()
+		err := retry(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, failingInvoker(3, nil))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(18*minBackoff))
+	})
+
+	t.Run("shared backoff escalates across calls", func(t *testing.T) {
+		retry := grpcclient.NewSharedBackoffRetry(cfg)
+
+		// Escalate the backoff and fail with a non retryable error: the delay is not reset.
+		err := retry(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, failingInvoker(3, status.Error(codes.Internal, "boom")))
+		require.Equal(t, codes.Internal, status.Code(err))
+
+		// After 3 retries the delay is at least 8 times the min backoff, minus the jitter.
+		assert.GreaterOrEqual(t, int64(timedCall(t, retry)), int64(7*minBackoff))
+	})
+
+	t.Run("shared backoff is reset on success", func(t *testing.T) {
+		retry := grpcclient.NewSharedBackoffRetry(cfg)
+
+		// Escalate the backoff, then succeed.
+		require.NoError(t, retry(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, failingInvoker(3, nil)))
+
+		assert.Less(t, int64(timedCall(t, retry)), int64(4*minBackoff))
+	})
+}
+
+func TestBackoffRetryMetrics(t *testing.T) {
+	calls := map[string]int{}
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls[method]++
+		if method == "/test/Push" && calls[method] == 3 {
+			return nil
+		}
+		return status.Error(codes.ResourceExhausted, "slow down")
+	}
+
+	cfg := backoff.Config{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 3,
+	}
+	reg := prometheus.NewPedanticRegistry()
+
+	// Interceptors created with the same registerer share the metrics.
+	require.NoError(t, grpcclient.NewBackoffRetryWithRegisterer(cfg, reg)(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker))
+	require.Error(t, grpcclient.NewSharedBackoffRetryWithRegisterer(cfg, reg)(context.Background(), "/test/Query", nil, nil, &grpc.ClientConn{}, invoker))
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP grpc_client_backoff_attempts_per_call Number of attempts per call, including retries after backing off because of rate limiting.
+		# TYPE grpc_client_backoff_attempts_per_call histogram
+		grpc_client_backoff_attempts_per_call_bucket{le="1"} 0
+		grpc_client_backoff_attempts_per_call_bucket{le="2"} 0
+		grpc_client_backoff_attempts_per_call_bucket{le="4"} 2
+		grpc_client_backoff_attempts_per_call_bucket{le="8"} 2
+		grpc_client_backoff_attempts_per_call_bucket{le="16"} 2
+		grpc_client_backoff_attempts_per_call_bucket{le="32"} 2
+		grpc_client_backoff_attempts_per_call_bucket{le="+Inf"} 2
+		grpc_client_backoff_attempts_per_call_sum 6
+		grpc_client_backoff_attempts_per_call_count 2
+		# HELP grpc_client_backoff_retries_total Total number of calls retried after backing off because of rate limiting.
+		# TYPE grpc_client_backoff_retries_total counter
+		grpc_client_backoff_retries_total{method="/test/Push"} 2
+		grpc_client_backoff_retries_total{method="/test/Query"} 2
+	`)))
+}
@@ -0,0 +1,47 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// NewCallTimeout creates a UnaryClientInterceptor bounding the duration of calls: calls
+// without a deadline get one defaultTimeout from now, and calls whose deadline is more
+// than maxTimeout from now get it moved earlier, to maxTimeout from now. Calls without a
+// deadline are bounded by maxTimeout when defaultTimeout isn't set. A zero timeout
+// disables the corresponding behavior.
+func NewCallTimeout(defaultTimeout, maxTimeout time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		current, hasCurrent := ctx.Deadline()
+		deadline, ok := effectiveDeadline(ctx, time.Now(), defaultTimeout, maxTimeout)
+		if !ok || (hasCurrent && deadline.Equal(current)) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// EffectiveDeadline returns the deadline a call issued with ctx gets once the timeouts
+// of cfg apply, see NewCallTimeout, e.g. for logging before issuing the call. It returns
+// false if the call has no deadline.
+func EffectiveDeadline(ctx context.Context, cfg Config) (time.Time, bool) {
+	return effectiveDeadline(ctx, time.Now(), cfg.DefaultCallTimeout, cfg.MaxCallTimeout)
+}
+
+func effectiveDeadline(ctx context.Context, now time.Time, defaultTimeout, maxTimeout time.Duration) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok && defaultTimeout > 0 {
+		deadline, ok = now.Add(defaultTimeout), true
+	}
+	if maxTimeout > 0 {
+		if limit := now.Add(maxTimeout); !ok || deadline.After(limit) {
+			deadline, ok = limit, true
+		}
+	}
+	return deadline, ok
+}
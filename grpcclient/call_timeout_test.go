@@ -0,0 +1,64 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestEffectiveDeadline(t *testing.T) {
+	for name, test := range map[string]struct {
+		cfg         grpcclient.Config
+		ctxTimeout  time.Duration // 0 means no deadline.
+		expected    time.Duration // From now, 0 means no deadline.
+		expectEqual bool          // Whether the deadline of the context is kept as is.
+	}{
+		"no deadline, no timeouts":                    {cfg: grpcclient.Config{}},
+		"deadline, no timeouts":                       {cfg: grpcclient.Config{}, ctxTimeout: time.Minute, expected: time.Minute, expectEqual: true},
+		"default timeout injected":                    {cfg: grpcclient.Config{DefaultCallTimeout: 5 * time.Second}, expected: 5 * time.Second},
+		"default timeout doesn't override deadline":   {cfg: grpcclient.Config{DefaultCallTimeout: 5 * time.Second}, ctxTimeout: time.Minute, expected: time.Minute, expectEqual: true},
+		"deadline capped":                             {cfg: grpcclient.Config{MaxCallTimeout: 10 * time.Second}, ctxTimeout: time.Minute, expected: 10 * time.Second},
+		"deadline below the cap":                      {cfg: grpcclient.Config{MaxCallTimeout: 10 * time.Second}, ctxTimeout: time.Second, expected: time.Second, expectEqual: true},
+		"no deadline capped":                          {cfg: grpcclient.Config{MaxCallTimeout: 10 * time.Second}, expected: 10 * time.Second},
+		"default timeout capped":                      {cfg: grpcclient.Config{DefaultCallTimeout: time.Minute, MaxCallTimeout: 10 * time.Second}, expected: 10 * time.Second},
+		"default timeout below the cap":               {cfg: grpcclient.Config{DefaultCallTimeout: 5 * time.Second, MaxCallTimeout: 10 * time.Second}, expected: 5 * time.Second},
+		"deadline capped regardless of default":       {cfg: grpcclient.Config{DefaultCallTimeout: 5 * time.Second, MaxCallTimeout: 10 * time.Second}, ctxTimeout: time.Minute, expected: 10 * time.Second},
+		"deadline kept regardless of default and cap": {cfg: grpcclient.Config{DefaultCallTimeout: 5 * time.Second, MaxCallTimeout: 10 * time.Second}, ctxTimeout: time.Second, expected: time.Second, expectEqual: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.ctxTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.ctxTimeout)
+				defer cancel()
+			}
+
+			deadline, ok := grpcclient.EffectiveDeadline(ctx, test.cfg)
+			if test.expected == 0 {
+				assert.False(t, ok)
+				return
+			}
+			require.True(t, ok)
+			assert.WithinDuration(t, time.Now().Add(test.expected), deadline, 100*time.Millisecond)
+			if ctxDeadline, _ := ctx.Deadline(); test.expectEqual {
+				assert.Equal(t, ctxDeadline, deadline)
+			}
+
+			// The interceptor applies the same deadline.
+			var applied time.Time
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				applied, _ = ctx.Deadline()
+				return nil
+			}
+			interceptor := grpcclient.NewCallTimeout(test.cfg.DefaultCallTimeout, test.cfg.MaxCallTimeout)
+			require.NoError(t, interceptor(ctx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker))
+			assert.WithinDuration(t, deadline, applied, 100*time.Millisecond)
+		})
+	}
+}
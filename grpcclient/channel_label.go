@@ -0,0 +1,33 @@
+package grpcclient
+
+import (
+	"context"
+
+	"google.golang.org/grpc/stats"
+)
+
+type channelLabelKey struct{}
+
+// ChannelLabelFromContext returns the channel label of the connection, set through
+// Config.ChannelLabel, from the contexts given to stats handlers.
+func ChannelLabelFromContext(ctx context.Context) (string, bool) {
+	label, ok := ctx.Value(channelLabelKey{}).(string)
+	return label, ok
+}
+
+// channelLabelStatsHandler tags the connection and its RPCs with a label.
+type channelLabelStatsHandler struct {
+	label string
+}
+
+func (h *channelLabelStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, channelLabelKey{}, h.label)
+}
+
+func (h *channelLabelStatsHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (h *channelLabelStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return context.WithValue(ctx, channelLabelKey{}, h.label)
+}
+
+func (h *channelLabelStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
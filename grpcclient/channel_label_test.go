@@ -0,0 +1,54 @@
+package grpcclient
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/stats"
+)
+
+type recordingStatsHandler struct {
+	connLabels []string
+	rpcLabels  []string
+}
+
+func (h *recordingStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *recordingStatsHandler) HandleRPC(ctx context.Context, _ stats.RPCStats) {
+	label, _ := ChannelLabelFromContext(ctx)
+	h.rpcLabels = append(h.rpcLabels, label)
+}
+
+func (h *recordingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *recordingStatsHandler) HandleConn(ctx context.Context, _ stats.ConnStats) {
+	label, _ := ChannelLabelFromContext(ctx)
+	h.connLabels = append(h.connLabels, label)
+}
+
+func TestChannelLabelStatsHandler(t *testing.T) {
+	recorder := &recordingStatsHandler{}
+	buf := &bytes.Buffer{}
+	h := multiStatsHandler{
+		&channelLabelStatsHandler{label: "ingester-client"},
+		recorder,
+		newKeepaliveStatsHandler(log.NewLogfmtLogger(buf)),
+	}
+
+	connCtx := h.TagConn(context.Background(), &stats.ConnTagInfo{})
+	h.HandleConn(connCtx, &stats.ConnBegin{Client: true})
+	rpcCtx := h.TagRPC(connCtx, &stats.RPCTagInfo{FullMethodName: "/test/method"})
+	h.HandleRPC(rpcCtx, &stats.Begin{Client: true})
+	h.HandleConn(connCtx, &stats.ConnEnd{Client: true})
+
+	assert.Equal(t, []string{"ingester-client", "ingester-client"}, recorder.connLabels)
+	assert.Equal(t, []string{"ingester-client"}, recorder.rpcLabels)
+	assert.Contains(t, buf.String(), `msg="gRPC connection established" remote_addr=unknown channel=ingester-client`)
+}
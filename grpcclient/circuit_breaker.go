@@ -0,0 +1,161 @@
+package grpcclient
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CircuitBreakerConfig configures the circuit breaker of a gRPC client.
+type CircuitBreakerConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+}
+
+// RegisterFlagsWithPrefix registers flags with prefix.
+func (cfg *CircuitBreakerConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.BoolVar(&cfg.Enabled, prefix+".circuit-breaker-enabled", false, "Fail calls fast, without sending them, after too many consecutive calls failed because the server is unavailable or overloaded.")
+	f.IntVar(&cfg.FailureThreshold, prefix+".circuit-breaker-failure-threshold", 10, "Number of consecutive failed calls opening the circuit breaker.")
+	f.DurationVar(&cfg.OpenTimeout, prefix+".circuit-breaker-open-timeout", 10*time.Second, "How long the circuit breaker stays open before letting a probe call through.")
+}
+
+// Validate the CircuitBreakerConfig.
+func (cfg *CircuitBreakerConfig) Validate() error {
+	if !cfg.Enabled {
+		return nil
+	}
+	if cfg.FailureThreshold <= 0 {
+		return fmt.Errorf("circuit breaker failure threshold must be positive, got %d", cfg.FailureThreshold)
+	}
+	if cfg.OpenTimeout <= 0 {
+		return fmt.Errorf("circuit breaker open timeout must be positive, got %s", cfg.OpenTimeout)
+	}
+	return nil
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// NewCircuitBreaker creates a UnaryClientInterceptor which opens the circuit after
+// cfg.FailureThreshold consecutive calls failed with Unavailable, ResourceExhausted or
+// DeadlineExceeded: calls then fail with Unavailable without being sent. After
+// cfg.OpenTimeout, the circuit is half-open and a single probe call is let through at a
+// time, closing the circuit if it succeeds or opening it again if it fails.
+//
+// DialOption places the circuit breaker before the rate limiter and the retries, so that
+// a call failing after all its retries counts as a single failure, and resets the shared
+// backoff (see Config.BackoffShared) when the circuit becomes half-open, so that the
+// probe doesn't wait for the delay escalated while the server was failing.
+func NewCircuitBreaker(cfg CircuitBreakerConfig) grpc.UnaryClientInterceptor {
+	return newCircuitBreaker(cfg, nil).unaryClientInterceptor()
+}
+
+type circuitBreaker struct {
+	cfg        CircuitBreakerConfig
+	onHalfOpen func() // Called when the circuit becomes half-open, if not nil.
+	now        func() time.Time
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int       // Consecutive failures while closed.
+	openedAt time.Time // When the circuit was last opened.
+	probing  bool      // Whether a probe call is in flight while half-open.
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig, onHalfOpen func()) *circuitBreaker {
+	return &circuitBreaker{cfg: cfg, onHalfOpen: onHalfOpen, now: time.Now}
+}
+
+func (b *circuitBreaker) unaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		allowed, probe := b.allow()
+		if !allowed {
+			return status.Error(codes.Unavailable, "circuit breaker is open")
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.done(probe, err)
+		return err
+	}
+}
+
+// allow returns whether a call can be sent, and whether it's the probe of the half-open
+// circuit.
+func (b *circuitBreaker) allow() (allowed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitOpen && b.now().Sub(b.openedAt) >= b.cfg.OpenTimeout {
+		b.state = circuitHalfOpen
+		if b.onHalfOpen != nil {
+			b.onHalfOpen()
+		}
+	}
+
+	switch b.state {
+	case circuitOpen:
+		return false, false
+	case circuitHalfOpen:
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// done records the result of a call let through by allow.
+func (b *circuitBreaker) done(probe bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	failed := isCircuitBreakerFailure(err)
+	if probe {
+		b.probing = false
+		if failed {
+			b.open()
+		} else {
+			b.state = circuitClosed
+			b.failures = 0
+		}
+		return
+	}
+
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == circuitClosed && b.failures >= b.cfg.FailureThreshold {
+		b.open()
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.state = circuitOpen
+	b.openedAt = b.now()
+	b.failures = 0
+}
+
+// isCircuitBreakerFailure returns whether err shows the server is unavailable or overloaded.
+func isCircuitBreakerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,159 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/backoff"
+)
+
+func TestCircuitBreakerResetsSharedBackoffWhenHalfOpen(t *testing.T) {
+	const minBackoff = 10 * time.Millisecond
+
+	shared := newSharedBackoff(backoff.Config{MinBackoff: minBackoff, MaxBackoff: time.Second, MaxRetries: 10})
+	breaker := newCircuitBreaker(CircuitBreakerConfig{Enabled: true, FailureThreshold: 2, OpenTimeout: time.Minute}, shared.reset)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	interceptor := breaker.unaryClientInterceptor()
+
+	calls := 0
+	failing := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		// The failing calls escalate the shared backoff, as the retries would.
+		shared.nextDelay()
+		return status.Error(codes.ResourceExhausted, "slow down")
+	}
+	call := func(invoker grpc.UnaryInvoker) error {
+		return interceptor(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker)
+	}
+
+	// Open the circuit.
+	for i := 0; i < 2; i++ {
+		require.Equal(t, codes.ResourceExhausted, status.Code(call(failing)))
+	}
+	for i := 0; i < 3; i++ {
+		shared.nextDelay()
+	}
+
+	// While open, calls fail fast.
+	err := call(failing)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "circuit breaker is open", status.Convert(err).Message())
+	require.Equal(t, 2, calls)
+
+	// Once half-open, the probe starts from the min backoff, and other calls still fail fast.
+	now = now.Add(time.Minute)
+	probe := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		assert.Less(t, int64(shared.nextDelay()), int64(2*minBackoff))
+		assert.Equal(t, codes.Unavailable, status.Code(call(failing)))
+		return nil
+	}
+	require.NoError(t, call(probe))
+	require.Equal(t, 3, calls)
+
+	// The successful probe closed the circuit.
+	require.Equal(t, codes.ResourceExhausted, status.Code(call(failing)))
+	require.Equal(t, 4, calls)
+}
+
+func TestCircuitBreakerReopensWhenProbeFails(t *testing.T) {
+	breaker := newCircuitBreaker(CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenTimeout: time.Minute}, nil)
+	now := time.Now()
+	breaker.now = func() time.Time { return now }
+	interceptor := breaker.unaryClientInterceptor()
+
+	calls := 0
+	failing := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		calls++
+		return status.Error(codes.Unavailable, "connection refused")
+	}
+	call := func() codes.Code {
+		return status.Code(interceptor(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, failing))
+	}
+
+	require.Equal(t, codes.Unavailable, call())
+	require.Equal(t, 1, calls)
+
+	now = now.Add(time.Minute)
+	require.Equal(t, codes.Unavailable, call())
+	require.Equal(t, 2, calls)
+
+	// The failed probe opened the circuit again, for another OpenTimeout.
+	now = now.Add(time.Minute - time.Second)
+	require.Equal(t, codes.Unavailable, call())
+	require.Equal(t, 2, calls)
+}
+
+func TestCircuitBreakerConfigValidate(t *testing.T) {
+	assert.NoError(t, (&CircuitBreakerConfig{}).Validate())
+	assert.NoError(t, (&CircuitBreakerConfig{Enabled: true, FailureThreshold: 1, OpenTimeout: time.Second}).Validate())
+	assert.EqualError(t, (&CircuitBreakerConfig{Enabled: true, OpenTimeout: time.Second}).Validate(), "circuit breaker failure threshold must be positive, got 0")
+	assert.EqualError(t, (&CircuitBreakerConfig{Enabled: true, FailureThreshold: 1}).Validate(), "circuit breaker open timeout must be positive, got 0s")
+}
+
+// rateLimitedHealthServer rejects every health check with ResourceExhausted.
+type rateLimitedHealthServer struct {
+	*health.Server
+	checks *atomic.Int32
+}
+
+func (s *rateLimitedHealthServer) Check(context.Context, *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	s.checks.Inc()
+	return nil, status.Error(codes.ResourceExhausted, "slow down")
+}
+
+func TestCircuitBreakerCountsCallsOutOfRetries(t *testing.T) {
+	checks := atomic.NewInt32(0)
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, &rateLimitedHealthServer{Server: health.NewServer(), checks: checks})
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	cfg := Config{
+		MaxRecvMsgSize:      1024,
+		MaxSendMsgSize:      1024,
+		BackoffOnRatelimits: true,
+		BackoffShared:       true,
+		BackoffConfig:       backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond, MaxRetries: 2},
+		CircuitBreaker:      CircuitBreakerConfig{Enabled: true, FailureThreshold: 2, OpenTimeout: time.Minute},
+	}
+	require.NoError(t, cfg.Validate(nil))
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	conn, err := grpc.Dial("bufconn", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+	client := grpc_health_v1.NewHealthClient(conn)
+
+	// The calls out of retries keep the code of their last attempt, and count as failures.
+	for i := 0; i < 2; i++ {
+		_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	}
+	require.Equal(t, int32(4), checks.Load())
+
+	// The circuit is open: calls fail fast, without being sent.
+	_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, "circuit breaker is open", status.Convert(err).Message())
+	assert.Equal(t, int32(4), checks.Load())
+}
@@ -0,0 +1,242 @@
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/dskit/grpcencoding/checksum"
+	"github.com/grafana/dskit/grpcencoding/snappy"
+)
+
+type compressionDisabledKey struct{}
+
+// WithCompressionDisabled returns a copy of ctx which makes calls issued with it skip
+// compression, regardless of the compression configured for the client.
+func WithCompressionDisabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, compressionDisabledKey{}, true)
+}
+
+func isCompressionDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(compressionDisabledKey{}).(bool)
+	return disabled
+}
+
+// NewUnaryCompressionDisabler creates a UnaryClientInterceptor which disables compression
+// for calls whose context was created with WithCompressionDisabled. It must come after
+// any other interceptor choosing the compressor in the chain.
+func NewUnaryCompressionDisabler() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if isCompressionDisabled(ctx) {
+			opts = withoutCompression(opts)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewStreamCompressionDisabler is the StreamClientInterceptor counterpart of
+// NewUnaryCompressionDisabler.
+func NewStreamCompressionDisabler() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if isCompressionDisabled(ctx) {
+			opts = withoutCompression(opts)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// NewCompressionDeadlineSkip creates a UnaryClientInterceptor which disables compression
+// for calls whose remaining deadline is below threshold, so that the time left isn't
+// spent compressing. Calls without a deadline are compressed normally. It must come
+// after any other interceptor choosing the compressor in the chain.
+func NewCompressionDeadlineSkip(threshold time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < threshold {
+			opts = withoutCompression(opts)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewUnaryPerMethodCompression creates a UnaryClientInterceptor which sets the compressor
+// of calls to the methods in overrides, keyed by full method name, overriding the
+// compression configured for the client. An empty compressor disables compression.
+func NewUnaryPerMethodCompression(overrides map[string]string) grpc.UnaryClientInterceptor {
+	overrides = copyOverrides(overrides)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if compressor, ok := overrides[method]; ok {
+			opts = append(opts[:len(opts):len(opts)], grpc.UseCompressor(compressor))
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewStreamPerMethodCompression is the StreamClientInterceptor counterpart of
+// NewUnaryPerMethodCompression.
+func NewStreamPerMethodCompression(overrides map[string]string) grpc.StreamClientInterceptor {
+	overrides = copyOverrides(overrides)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if compressor, ok := overrides[method]; ok {
+			opts = append(opts[:len(opts):len(opts)], grpc.UseCompressor(compressor))
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// CompressorSelector chooses the compressor of a call. An empty compressor disables
+// compression.
+type CompressorSelector func(ctx context.Context, method string) string
+
+// NewUnaryCompressorSelection creates a UnaryClientInterceptor which sets the compressor
+// of each call to the one chosen by selector, overriding the compression configured for
+// the client. Calls fail without being sent if the chosen compressor isn't registered.
+func NewUnaryCompressorSelection(selector CompressorSelector) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		opts, err := selectCompressor(ctx, selector, method, opts)
+		if err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// NewStreamCompressorSelection is the StreamClientInterceptor counterpart of
+// NewUnaryCompressorSelection.
+func NewStreamCompressorSelection(selector CompressorSelector) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		opts, err := selectCompressor(ctx, selector, method, opts)
+		if err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+func selectCompressor(ctx context.Context, selector CompressorSelector, method string, opts []grpc.CallOption) ([]grpc.CallOption, error) {
+	compressor := selector(ctx, method)
+	if compressor != "" && encoding.GetCompressor(compressor) == nil {
+		return nil, fmt.Errorf("%w: %s is not registered", ErrUnsupportedCompression, compressor)
+	}
+	return append(opts[:len(opts):len(opts)], grpc.UseCompressor(compressor)), nil
+}
+
+type compressorKey struct{}
+
+// CompressorFromContext returns the compressor of the call ctx belongs to, as stored by
+// the interceptors created by NewUnaryCompressorRecorder and NewStreamCompressorRecorder,
+// e.g. for logging. An empty compressor means the call isn't compressed. It returns false
+// if the compressor wasn't stored, which is the case when the client config doesn't
+// configure any compression.
+func CompressorFromContext(ctx context.Context) (string, bool) {
+	compressor, ok := ctx.Value(compressorKey{}).(string)
+	return compressor, ok
+}
+
+// NewUnaryCompressorRecorder creates a UnaryClientInterceptor storing the compressor of
+// each call in its context, for the interceptors which come after it in the chain, see
+// CompressorFromContext. It must come after any interceptor choosing the compressor.
+func NewUnaryCompressorRecorder() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(context.WithValue(ctx, compressorKey{}, callCompressor(opts)), method, req, reply, cc, opts...)
+	}
+}
+
+// NewStreamCompressorRecorder is the StreamClientInterceptor counterpart of
+// NewUnaryCompressorRecorder.
+func NewStreamCompressorRecorder() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(context.WithValue(ctx, compressorKey{}, callCompressor(opts)), desc, cc, method, opts...)
+	}
+}
+
+// callCompressor returns the compressor set by the last compressor option in opts, which
+// include the default call options.
+func callCompressor(opts []grpc.CallOption) string {
+	compressor := ""
+	for _, opt := range opts {
+		if c, ok := opt.(grpc.CompressorCallOption); ok {
+			compressor = c.CompressorType
+		}
+	}
+	return compressor
+}
+
+// copyOverrides protects the interceptors from later changes to the caller's map.
+func copyOverrides(overrides map[string]string) map[string]string {
+	c := make(map[string]string, len(overrides))
+	for method, compressor := range overrides {
+		c[method] = compressor
+	}
+	return c
+}
+
+// acceptEncodingHeader advertises the compressions accepted for responses. gRPC only sets it
+// when compressing requests, to the request compression.
+const acceptEncodingHeader = "grpc-accept-encoding"
+
+// NewAcceptCompression creates interceptors advertising the given compressions to the
+// server as accepted for responses, without changing the compression of requests. The
+// compressions are only advertised on the calls sent uncompressed, since gRPC advertises
+// the request compression otherwise, and a second header would be ambiguous. Without
+// compressions, the interceptors are no-ops.
+//
+// The advertisement is advisory: it's up to the server to compress the responses
+// accordingly, which grpc-go servers don't do, since they compress the responses the
+// same way as the requests, unless configured with a compressor of their own.
+func NewAcceptCompression(compressions ...string) (grpc.UnaryClientInterceptor, grpc.StreamClientInterceptor) {
+	accepted := strings.Join(compressions, ",")
+
+	withAccepted := func(ctx context.Context, opts []grpc.CallOption) context.Context {
+		if accepted == "" || callCompressor(opts) != "" {
+			return ctx
+		}
+		return metadata.AppendToOutgoingContext(ctx, acceptEncodingHeader, accepted)
+	}
+	unary := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withAccepted(ctx, opts), method, req, reply, cc, opts...)
+	}
+	stream := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withAccepted(ctx, opts), desc, cc, method, opts...)
+	}
+	return unary, stream
+}
+
+// withoutCompression returns a copy of opts overriding any compressor set for the call,
+// including the one set through the default call options.
+func withoutCompression(opts []grpc.CallOption) []grpc.CallOption {
+	return append(opts[:len(opts):len(opts)], grpc.UseCompressor(""))
+}
+
+// snappyMaxFrameSize returns the frame size of the registered snappy compressor, see
+// snappy.RegisterWithMaxFrameSize.
+func snappyMaxFrameSize() int {
+	if c, ok := encoding.GetCompressor(snappy.Name).(interface{ MaxFrameSize() int }); ok {
+		return c.MaxFrameSize()
+	}
+	return snappy.MaxFrameSize
+}
+
+// MaxCompressedSize returns the worst-case size of a message of the given size once
+// compressed with the given compression. Incompressible messages grow when compressed,
+// and gRPC checks MaxSendMsgSize against the compressed size.
+func MaxCompressedSize(compression string, size int) int {
+	switch compression {
+	case gzip.Name:
+		// Header and trailer, plus the overhead of each stored deflate block and of the final
+		// empty block.
+		return size + 18 + 5*(size/16383+2)
+	case snappy.Name:
+		// Stream identifier, plus the header and checksum of each chunk.
+		return size + 10 + 8*(size/snappyMaxFrameSize()+1)
+	case checksum.SnappyName:
+		return MaxCompressedSize(snappy.Name, size) + 4
+	default:
+		return size
+	}
+}
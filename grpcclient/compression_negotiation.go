@@ -0,0 +1,140 @@
+package grpcclient
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewCompressionNegotiation creates interceptors which send calls uncompressed once the
+// server is found not to support their compressor: either because the grpc-accept-encoding
+// header of a response doesn't list it, or because the server rejected a call with the
+// Unimplemented error returned by gRPC for unknown compressors. Unary calls rejected this
+// way are retried once uncompressed, since the server didn't process them. A warning is
+// logged the first time a compressor is found to be unsupported.
+//
+// The interceptors share their state, which should be per connection: DialOption creates
+// new ones every time it's called. They must come after any interceptor choosing the
+// compressor in the chain.
+func NewCompressionNegotiation(logger log.Logger) (grpc.UnaryClientInterceptor, grpc.StreamClientInterceptor) {
+	n := &compressionNegotiation{logger: logger}
+
+	unary := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		compressor := callCompressor(opts)
+		if compressor == "" || compressor == encoding.Identity {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if n.isUnsupported(compressor) {
+			return invoker(ctx, method, req, reply, cc, withoutCompression(opts)...)
+		}
+
+		var header metadata.MD
+		err := invoker(ctx, method, req, reply, cc, append(opts[:len(opts):len(opts)], grpc.Header(&header))...)
+		if isUnsupportedCompressionError(err) {
+			n.markUnsupported(compressor, err.Error())
+			return invoker(ctx, method, req, reply, cc, withoutCompression(opts)...)
+		}
+		n.observeHeader(compressor, header)
+		return err
+	}
+
+	stream := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		compressor := callCompressor(opts)
+		if compressor == "" || compressor == encoding.Identity {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+		if n.isUnsupported(compressor) {
+			return streamer(ctx, desc, cc, method, withoutCompression(opts)...)
+		}
+
+		s, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			n.observeError(compressor, err)
+			return nil, err
+		}
+		return &negotiatedClientStream{ClientStream: s, negotiation: n, compressor: compressor}, nil
+	}
+
+	return unary, stream
+}
+
+type compressionNegotiation struct {
+	logger log.Logger
+
+	// unsupported holds the compressors not supported by the server.
+	unsupported sync.Map
+}
+
+func (n *compressionNegotiation) isUnsupported(compressor string) bool {
+	_, ok := n.unsupported.Load(compressor)
+	return ok
+}
+
+func (n *compressionNegotiation) markUnsupported(compressor, reason string) {
+	if _, loaded := n.unsupported.LoadOrStore(compressor, struct{}{}); !loaded {
+		level.Warn(n.logger).Log("msg", "the server doesn't support the gRPC compressor, falling back to uncompressed calls", "compressor", compressor, "reason", reason)
+	}
+}
+
+// observeHeader marks compressor as unsupported if the response header lists the
+// compressions accepted by the server and compressor isn't one of them.
+func (n *compressionNegotiation) observeHeader(compressor string, header metadata.MD) {
+	accepted := header.Get(acceptEncodingHeader)
+	if len(accepted) == 0 {
+		return
+	}
+	for _, value := range accepted {
+		for _, c := range strings.Split(value, ",") {
+			if strings.TrimSpace(c) == compressor {
+				return
+			}
+		}
+	}
+	n.markUnsupported(compressor, "not listed in the "+acceptEncodingHeader+" response header: "+strings.Join(accepted, ","))
+}
+
+func (n *compressionNegotiation) observeError(compressor string, err error) {
+	if isUnsupportedCompressionError(err) {
+		n.markUnsupported(compressor, err.Error())
+	}
+}
+
+// isUnsupportedCompressionError returns whether err is the error returned by gRPC servers
+// receiving a message compressed with a compressor they don't have.
+func isUnsupportedCompressionError(err error) bool {
+	s, ok := status.FromError(err)
+	return ok && s.Code() == codes.Unimplemented && strings.Contains(s.Message(), "Decompressor is not installed")
+}
+
+// negotiatedClientStream checks the header and errors of a compressed stream, so that
+// the following streams are sent uncompressed if the server doesn't support the
+// compressor. The stream itself isn't retried.
+type negotiatedClientStream struct {
+	grpc.ClientStream
+	negotiation *compressionNegotiation
+	compressor  string
+}
+
+func (s *negotiatedClientStream) Header() (metadata.MD, error) {
+	header, err := s.ClientStream.Header()
+	if err == nil {
+		s.negotiation.observeHeader(s.compressor, header)
+	}
+	return header, err
+}
+
+func (s *negotiatedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.negotiation.observeError(s.compressor, err)
+	}
+	return err
+}
@@ -0,0 +1,122 @@
+package grpcclient_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+// compressorOf returns the compressor set by the last compressor option in opts.
+func compressorOf(opts []grpc.CallOption) string {
+	compressor := ""
+	for _, opt := range opts {
+		if c, ok := opt.(grpc.CompressorCallOption); ok {
+			compressor = c.CompressorType
+		}
+	}
+	return compressor
+}
+
+func TestCompressionNegotiation(t *testing.T) {
+	t.Run("falls back to uncompressed calls when the server rejects the compressor", func(t *testing.T) {
+		var logs bytes.Buffer
+		unary, _ := grpcclient.NewCompressionNegotiation(log.NewLogfmtLogger(&logs))
+
+		// The server doesn't have the snappy decompressor.
+		var compressors []string
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			compressor := compressorOf(opts)
+			compressors = append(compressors, compressor)
+			if compressor == "snappy" {
+				return status.Errorf(codes.Unimplemented, "grpc: Decompressor is not installed for grpc-encoding %q", compressor)
+			}
+			return nil
+		}
+
+		opts := []grpc.CallOption{grpc.UseCompressor("snappy")}
+		require.NoError(t, unary(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker, opts...))
+		require.NoError(t, unary(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker, opts...))
+
+		// The first call is retried uncompressed, the second one is sent uncompressed.
+		assert.Equal(t, []string{"snappy", "", ""}, compressors)
+		assert.Equal(t, 1, strings.Count(logs.String(), "falling back to uncompressed calls"))
+	})
+
+	t.Run("falls back to uncompressed calls when the server doesn't accept the compressor", func(t *testing.T) {
+		var logs bytes.Buffer
+		unary, _ := grpcclient.NewCompressionNegotiation(log.NewLogfmtLogger(&logs))
+
+		var compressors []string
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			compressors = append(compressors, compressorOf(opts))
+			for _, opt := range opts {
+				if h, ok := opt.(grpc.HeaderCallOption); ok {
+					*h.HeaderAddr = metadata.Pairs("grpc-accept-encoding", "identity, gzip")
+				}
+			}
+			return nil
+		}
+
+		require.NoError(t, unary(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("snappy")))
+		require.NoError(t, unary(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("snappy")))
+		require.NoError(t, unary(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("gzip")))
+
+		assert.Equal(t, []string{"snappy", "", "gzip"}, compressors)
+		assert.Equal(t, 1, strings.Count(logs.String(), "falling back to uncompressed calls"))
+	})
+
+	t.Run("keeps the compression of calls accepted by the server", func(t *testing.T) {
+		unary, _ := grpcclient.NewCompressionNegotiation(log.NewNopLogger())
+
+		var compressors []string
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			compressors = append(compressors, compressorOf(opts))
+			return status.Error(codes.Unimplemented, "unknown method")
+		}
+
+		err := unary(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("snappy"))
+		require.Equal(t, codes.Unimplemented, status.Code(err))
+		err = unary(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("snappy"))
+		require.Equal(t, codes.Unimplemented, status.Code(err))
+
+		assert.Equal(t, []string{"snappy", "snappy"}, compressors)
+	})
+
+	t.Run("streams fall back to uncompressed once the server rejected the compressor", func(t *testing.T) {
+		_, stream := grpcclient.NewCompressionNegotiation(log.NewNopLogger())
+
+		var compressors []string
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			compressors = append(compressors, compressorOf(opts))
+			return &failingClientStream{err: status.Error(codes.Unimplemented, `grpc: Decompressor is not installed for grpc-encoding "snappy"`)}, nil
+		}
+
+		s, err := stream(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/Stream", streamer, grpc.UseCompressor("snappy"))
+		require.NoError(t, err)
+		require.Equal(t, codes.Unimplemented, status.Code(s.RecvMsg(nil)))
+
+		_, err = stream(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/Stream", streamer, grpc.UseCompressor("snappy"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"snappy", ""}, compressors)
+	})
+}
+
+type failingClientStream struct {
+	grpc.ClientStream
+	err error
+}
+
+func (s *failingClientStream) RecvMsg(interface{}) error {
+	return s.err
+}
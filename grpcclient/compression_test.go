@@ -0,0 +1,494 @@
+package grpcclient_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+// lastCompressor returns the compressor set by the last CompressorCallOption in opts, if any.
+func lastCompressor(opts []grpc.CallOption) (string, bool) {
+	compressor, found := "", false
+	for _, opt := range opts {
+		if c, ok := opt.(grpc.CompressorCallOption); ok {
+			compressor, found = c.CompressorType, true
+		}
+	}
+	return compressor, found
+}
+
+func TestUnaryCompressionDisabler(t *testing.T) {
+	var opts []grpc.CallOption
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, callOpts ...grpc.CallOption) error {
+		opts = callOpts
+		return nil
+	}
+	interceptor := grpcclient.NewUnaryCompressionDisabler()
+
+	// Compression configured by previous interceptors or defaults is left untouched.
+	require.NoError(t, interceptor(context.Background(), "/test/method", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("gzip")))
+	compressor, ok := lastCompressor(opts)
+	assert.True(t, ok)
+	assert.Equal(t, "gzip", compressor)
+
+	ctx := grpcclient.WithCompressionDisabled(context.Background())
+	require.NoError(t, interceptor(ctx, "/test/method", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("gzip")))
+	compressor, ok = lastCompressor(opts)
+	assert.True(t, ok)
+	assert.Equal(t, "", compressor)
+}
+
+func TestStreamCompressionDisabler(t *testing.T) {
+	var opts []grpc.CallOption
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		opts = callOpts
+		return nil, nil
+	}
+	interceptor := grpcclient.NewStreamCompressionDisabler()
+
+	_, err := interceptor(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/method", streamer)
+	require.NoError(t, err)
+	_, ok := lastCompressor(opts)
+	assert.False(t, ok)
+
+	_, err = interceptor(grpcclient.WithCompressionDisabled(context.Background()), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/method", streamer)
+	require.NoError(t, err)
+	compressor, ok := lastCompressor(opts)
+	assert.True(t, ok)
+	assert.Equal(t, "", compressor)
+}
+
+func TestCompressionDeadlineSkip(t *testing.T) {
+	var opts []grpc.CallOption
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, callOpts ...grpc.CallOption) error {
+		opts = callOpts
+		return nil
+	}
+	interceptor := grpcclient.NewCompressionDeadlineSkip(100 * time.Millisecond)
+
+	for name, test := range map[string]struct {
+		timeout    time.Duration
+		compressed bool
+	}{
+		"no deadline":       {compressed: true},
+		"generous deadline": {timeout: time.Minute, compressed: true},
+		"tight deadline":    {timeout: 10 * time.Millisecond, compressed: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+
+			require.NoError(t, interceptor(ctx, "/test/method", nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("gzip")))
+			compressor, ok := lastCompressor(opts)
+			assert.True(t, ok)
+			if test.compressed {
+				assert.Equal(t, "gzip", compressor)
+			} else {
+				assert.Equal(t, "", compressor)
+			}
+		})
+	}
+}
+
+func TestUnaryPerMethodCompression(t *testing.T) {
+	var opts []grpc.CallOption
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, callOpts ...grpc.CallOption) error {
+		opts = callOpts
+		return nil
+	}
+	interceptor := grpcclient.NewUnaryPerMethodCompression(map[string]string{
+		"/test/Query": "snappy",
+		"/test/Admin": "",
+	})
+
+	tests := map[string]struct {
+		method             string
+		expectedCompressor string
+		expectedOverride   bool
+	}{
+		"method overridden with a compressor": {
+			method:             "/test/Query",
+			expectedCompressor: "snappy",
+			expectedOverride:   true,
+		},
+		"method overridden without compression": {
+			method:             "/test/Admin",
+			expectedCompressor: "",
+			expectedOverride:   true,
+		},
+		"method not overridden falls back to the global compression": {
+			method:             "/test/Other",
+			expectedCompressor: "gzip",
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			// The global compression is set through the default call options, which come first.
+			require.NoError(t, interceptor(context.Background(), testData.method, nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("gzip")))
+			compressor, ok := lastCompressor(opts)
+			assert.True(t, ok)
+			assert.Equal(t, testData.expectedCompressor, compressor)
+			if testData.expectedOverride {
+				assert.Len(t, opts, 2)
+			} else {
+				assert.Len(t, opts, 1)
+			}
+		})
+	}
+}
+
+func TestStreamPerMethodCompression(t *testing.T) {
+	var opts []grpc.CallOption
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		opts = callOpts
+		return nil, nil
+	}
+	interceptor := grpcclient.NewStreamPerMethodCompression(map[string]string{"/test/Query": "snappy"})
+
+	_, err := interceptor(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/Query", streamer)
+	require.NoError(t, err)
+	compressor, ok := lastCompressor(opts)
+	assert.True(t, ok)
+	assert.Equal(t, "snappy", compressor)
+
+	_, err = interceptor(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/Other", streamer)
+	require.NoError(t, err)
+	_, ok = lastCompressor(opts)
+	assert.False(t, ok)
+}
+
+func TestConfigValidatePerMethodCompression(t *testing.T) {
+	cfg := grpcclient.Config{PerMethodCompression: map[string]string{
+		"/test/Query": "snappy",
+		"/test/Push":  "gzip",
+		"/test/Admin": "",
+	}}
+	require.NoError(t, cfg.Validate(nil))
+
+	cfg.PerMethodCompression["/test/Other"] = "lz4"
+	assert.EqualError(t, cfg.Validate(nil), "invalid compression for method /test/Other: unsupported compression type: lz4")
+}
+
+func TestCompressorSelection(t *testing.T) {
+	selector := func(ctx context.Context, method string) string {
+		switch method {
+		case "/test/Push":
+			return "snappy"
+		case "/test/Query":
+			return "gzip"
+		case "/test/Unknown":
+			return "unregistered"
+		default:
+			return ""
+		}
+	}
+
+	var opts []grpc.CallOption
+	invoked := false
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, callOpts ...grpc.CallOption) error {
+		opts, invoked = callOpts, true
+		return nil
+	}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		opts, invoked = callOpts, true
+		return nil, nil
+	}
+	unary := grpcclient.NewUnaryCompressorSelection(selector)
+	stream := grpcclient.NewStreamCompressorSelection(selector)
+
+	for _, method := range []string{"/test/Push", "/test/Query", "/test/Other"} {
+		expected := selector(context.Background(), method)
+
+		// The global compression is set through the default call options, which come first.
+		require.NoError(t, unary(context.Background(), method, nil, nil, &grpc.ClientConn{}, invoker, grpc.UseCompressor("gzip")))
+		compressor, ok := lastCompressor(opts)
+		assert.True(t, ok)
+		assert.Equal(t, expected, compressor, method)
+
+		_, err := stream(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, method, streamer)
+		require.NoError(t, err)
+		compressor, ok = lastCompressor(opts)
+		assert.True(t, ok)
+		assert.Equal(t, expected, compressor, method)
+	}
+
+	// Calls choosing an unregistered compressor fail without being sent.
+	invoked = false
+	err := unary(context.Background(), "/test/Unknown", nil, nil, &grpc.ClientConn{}, invoker)
+	assert.True(t, errors.Is(err, grpcclient.ErrUnsupportedCompression))
+	_, err = stream(context.Background(), &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/Unknown", streamer)
+	assert.True(t, errors.Is(err, grpcclient.ErrUnsupportedCompression))
+	assert.False(t, invoked)
+}
+
+func TestMaxCompressedSize(t *testing.T) {
+	for _, compression := range []string{"gzip", "snappy", "snappy-crc"} {
+		c := encoding.GetCompressor(compression)
+		require.NotNil(t, c, compression)
+
+		for _, size := range []int{0, 1, 100, 1024, 100 << 10} {
+			// Random data is incompressible.
+			msg := make([]byte, size)
+			_, err := rand.New(rand.NewSource(int64(size))).Read(msg)
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			w, err := c.Compress(&buf)
+			require.NoError(t, err)
+			_, err = w.Write(msg)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			assert.LessOrEqual(t, buf.Len(), grpcclient.MaxCompressedSize(compression, size), fmt.Sprintf("%s with %d bytes", compression, size))
+		}
+	}
+	assert.Equal(t, 100, grpcclient.MaxCompressedSize("", 100))
+}
+
+func TestConfigValidateMaxSendMsgSizeWithCompression(t *testing.T) {
+	for name, test := range map[string]struct {
+		cfg         grpcclient.Config
+		expectedErr bool
+	}{
+		"small limit without compression": {
+			cfg: grpcclient.Config{MaxSendMsgSize: 100},
+		},
+		"small limit with compression": {
+			cfg:         grpcclient.Config{MaxSendMsgSize: 100, GRPCCompression: "gzip"},
+			expectedErr: true,
+		},
+		"small limit with per-method compression": {
+			cfg:         grpcclient.Config{MaxSendMsgSize: 100, PerMethodCompression: map[string]string{"/test/Query": "snappy"}},
+			expectedErr: true,
+		},
+		"small limit with per-method compression disabled": {
+			cfg: grpcclient.Config{MaxSendMsgSize: 100, PerMethodCompression: map[string]string{"/test/Query": ""}},
+		},
+		"sane limit with compression": {
+			cfg: grpcclient.Config{MaxSendMsgSize: 1024, GRPCCompression: "gzip"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := test.cfg.Validate(nil)
+			if test.expectedErr {
+				assert.EqualError(t, err, "gRPC client max send message size 100 is too small with compression enabled, since compression can make small messages larger: it must be at least 1024 bytes")
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+// inHeaderRecorder is a server stats.Handler recording the last request header received.
+type inHeaderRecorder struct {
+	mtx    sync.Mutex
+	header *stats.InHeader
+}
+
+func (r *inHeaderRecorder) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (r *inHeaderRecorder) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if header, ok := s.(*stats.InHeader); ok {
+		r.mtx.Lock()
+		r.header = header
+		r.mtx.Unlock()
+	}
+}
+
+func (r *inHeaderRecorder) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (r *inHeaderRecorder) HandleConn(context.Context, stats.ConnStats) {}
+
+func TestDialOptionWithAcceptCompression(t *testing.T) {
+	recorder := &inHeaderRecorder{}
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.StatsHandler(recorder))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	for name, test := range map[string]struct {
+		compression            string
+		expectedAcceptEncoding []string
+	}{
+		// The request is sent uncompressed, but snappy is advertised for the response.
+		"uncompressed requests": {compression: "", expectedAcceptEncoding: []string{"snappy"}},
+		// gRPC advertises the request compression, which isn't advertised twice.
+		"compressed requests": {compression: "gzip", expectedAcceptEncoding: []string{"gzip"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := grpcclient.Config{
+				MaxRecvMsgSize:    1024,
+				MaxSendMsgSize:    1024,
+				GRPCCompression:   test.compression,
+				AcceptCompression: []string{"snappy"},
+			}
+			require.NoError(t, cfg.Validate(nil))
+			opts, err := cfg.DialOption(nil, nil)
+			require.NoError(t, err)
+			opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}))
+
+			conn, err := grpc.Dial("bufconn", opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			require.NoError(t, err)
+
+			recorder.mtx.Lock()
+			defer recorder.mtx.Unlock()
+			require.NotNil(t, recorder.header)
+			assert.Equal(t, test.compression, recorder.header.Compression)
+			assert.Equal(t, test.expectedAcceptEncoding, recorder.header.Header.Get("grpc-accept-encoding"))
+		})
+	}
+}
+
+func TestConfigValidateAcceptCompression(t *testing.T) {
+	cfg := grpcclient.Config{AcceptCompression: []string{"gzip", "lz4"}}
+	assert.EqualError(t, cfg.Validate(nil), "invalid accepted compression: unsupported compression type: lz4")
+}
+
+func TestCompressorFromContext(t *testing.T) {
+	_, ok := grpcclient.CompressorFromContext(context.Background())
+	assert.False(t, ok)
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:       1024,
+		MaxSendMsgSize:       1024,
+		GRPCCompression:      "snappy",
+		PerMethodCompression: map[string]string{"/test/Push": "gzip", "/test/Admin": ""},
+	}
+	require.NoError(t, cfg.Validate(nil))
+
+	compressors := map[string]string{}
+	recorder := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		compressor, ok := grpcclient.CompressorFromContext(ctx)
+		assert.True(t, ok)
+		compressors[method] = compressor
+		return nil
+	}
+	opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{recorder}, nil)
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial("localhost:0", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, method := range []string{"/test/Query", "/test/Push", "/test/Admin"} {
+		require.NoError(t, conn.Invoke(context.Background(), method, nil, nil))
+	}
+	require.NoError(t, conn.Invoke(grpcclient.WithCompressionDisabled(context.Background()), "/test/Disabled", nil, nil))
+
+	assert.Equal(t, map[string]string{
+		"/test/Query":    "snappy",
+		"/test/Push":     "gzip",
+		"/test/Admin":    "",
+		"/test/Disabled": "",
+	}, compressors)
+}
+
+func TestConfigValidateCompressionCombinations(t *testing.T) {
+	const (
+		noError                = ""
+		adaptiveWithChecksum   = "adaptive compression can't be used with the snappy-crc compression, since it may switch to a compression without checksum verification"
+		deadlineSkipNoCompress = "compression deadline skip can't be set with compression disabled"
+	)
+
+	for name, test := range map[string]struct {
+		cfg         grpcclient.Config
+		expectedErr string
+	}{
+		"no compression":                    {cfg: grpcclient.Config{}, expectedErr: noError},
+		"gzip":                              {cfg: grpcclient.Config{GRPCCompression: "gzip"}, expectedErr: noError},
+		"snappy":                            {cfg: grpcclient.Config{GRPCCompression: "snappy"}, expectedErr: noError},
+		"snappy-crc":                        {cfg: grpcclient.Config{GRPCCompression: "snappy-crc"}, expectedErr: noError},
+		"unsupported":                       {cfg: grpcclient.Config{GRPCCompression: "zstd"}, expectedErr: "unsupported compression type: zstd"},
+		"adaptive without compression":      {cfg: grpcclient.Config{AdaptiveCompression: true}, expectedErr: noError},
+		"adaptive with gzip":                {cfg: grpcclient.Config{GRPCCompression: "gzip", AdaptiveCompression: true}, expectedErr: noError},
+		"adaptive with snappy":              {cfg: grpcclient.Config{GRPCCompression: "snappy", AdaptiveCompression: true}, expectedErr: noError},
+		"adaptive with snappy-crc":          {cfg: grpcclient.Config{GRPCCompression: "snappy-crc", AdaptiveCompression: true}, expectedErr: adaptiveWithChecksum},
+		"deadline skip without compression": {cfg: grpcclient.Config{CompressionDeadlineSkipBelow: time.Second}, expectedErr: deadlineSkipNoCompress},
+		"negotiation with snappy":           {cfg: grpcclient.Config{GRPCCompression: "snappy", CompressionNegotiation: true}, expectedErr: noError},
+		"server preferred with snappy-crc": {
+			cfg:         grpcclient.Config{GRPCCompression: "snappy-crc", ServerPreferredCompression: true},
+			expectedErr: "server preferred compression can't be used with the snappy-crc compression, since it may switch to a compression without checksum verification",
+		},
+		"negotiation with snappy-crc": {
+			cfg:         grpcclient.Config{GRPCCompression: "snappy-crc", CompressionNegotiation: true},
+			expectedErr: "compression negotiation can't be used with the snappy-crc compression, since it may fall back to calls without checksum verification",
+		},
+		"deadline skip with gzip":       {cfg: grpcclient.Config{GRPCCompression: "gzip", CompressionDeadlineSkipBelow: time.Second}, expectedErr: noError},
+		"deadline skip with snappy":     {cfg: grpcclient.Config{GRPCCompression: "snappy", CompressionDeadlineSkipBelow: time.Second}, expectedErr: noError},
+		"deadline skip with snappy-crc": {cfg: grpcclient.Config{GRPCCompression: "snappy-crc", CompressionDeadlineSkipBelow: time.Second}, expectedErr: noError},
+		"deadline skip with adaptive":   {cfg: grpcclient.Config{AdaptiveCompression: true, CompressionDeadlineSkipBelow: time.Second}, expectedErr: noError},
+		"deadline skip with per-method compression": {
+			cfg:         grpcclient.Config{PerMethodCompression: map[string]string{"/test/Push": "snappy-crc"}, CompressionDeadlineSkipBelow: time.Second},
+			expectedErr: noError,
+		},
+		"deadline skip with per-method compression disabled": {
+			cfg:         grpcclient.Config{PerMethodCompression: map[string]string{"/test/Push": ""}, CompressionDeadlineSkipBelow: time.Second},
+			expectedErr: deadlineSkipNoCompress,
+		},
+		"adaptive with per-method snappy-crc": {
+			cfg:         grpcclient.Config{AdaptiveCompression: true, PerMethodCompression: map[string]string{"/test/Push": "snappy-crc"}},
+			expectedErr: noError,
+		},
+		"min compress size with snappy": {cfg: grpcclient.Config{GRPCCompression: "snappy", MinCompressSize: 1024}, expectedErr: noError},
+		"min compress size without compression": {
+			cfg:         grpcclient.Config{MinCompressSize: 1024},
+			expectedErr: "min compress size can't be set with compression disabled",
+		},
+		"negative min compress size": {
+			cfg:         grpcclient.Config{GRPCCompression: "snappy", MinCompressSize: -1},
+			expectedErr: "gRPC client min compress size can't be negative, got -1",
+		},
+		"unsupported per-method compression": {
+			cfg:         grpcclient.Config{PerMethodCompression: map[string]string{"/test/Push": "zstd"}},
+			expectedErr: "invalid compression for method /test/Push: unsupported compression type: zstd",
+		},
+		"unsupported accepted compression": {
+			cfg:         grpcclient.Config{AcceptCompression: []string{"gzip", "zstd"}},
+			expectedErr: "invalid accepted compression: unsupported compression type: zstd",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := test.cfg.Validate(nil)
+			if test.expectedErr == noError {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectedErr)
+			}
+		})
+	}
+}
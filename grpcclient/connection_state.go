@@ -0,0 +1,45 @@
+package grpcclient
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// Dial creates a client connection to addr with the options returned by DialOption for
+// the given interceptors, e.g. from a client pool factory. If OnStateChange is set, it's
+// called every time the state of the connection changes, see WatchConnectionState.
+func (cfg *Config) Dial(addr string, unaryClientInterceptors []grpc.UnaryClientInterceptor, streamClientInterceptors []grpc.StreamClientInterceptor) (*grpc.ClientConn, error) {
+	opts, err := cfg.DialOption(unaryClientInterceptors, streamClientInterceptors)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.OnStateChange != nil {
+		WatchConnectionState(conn, addr, cfg.OnStateChange)
+	}
+	return conn, nil
+}
+
+// WatchConnectionState calls onStateChange, from a dedicated goroutine, every time the
+// state of conn changes (e.g. between CONNECTING, READY and TRANSIENT_FAILURE). The
+// goroutine exits once conn is closed, after reporting the SHUTDOWN state, or right away
+// if conn is already closed. addr is passed through to onStateChange, to tell
+// connections apart when the same callback is used for many of them (e.g. from a
+// client pool factory).
+func WatchConnectionState(conn *grpc.ClientConn, addr string, onStateChange func(addr string, state connectivity.State)) {
+	go func() {
+		// The state can't change after SHUTDOWN, so waiting for it would block forever.
+		for state := conn.GetState(); state != connectivity.Shutdown; {
+			if !conn.WaitForStateChange(context.Background(), state) {
+				return
+			}
+			state = conn.GetState()
+			onStateChange(addr, state)
+		}
+	}()
+}
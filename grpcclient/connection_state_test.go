@@ -0,0 +1,123 @@
+package grpcclient_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestWatchConnectionState(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+
+	startServer := func(listener net.Listener) *grpc.Server {
+		server := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+		go func() {
+			_ = server.Serve(listener)
+		}()
+		return server
+	}
+	server := startServer(listener)
+
+	var (
+		mtx    sync.Mutex
+		states []connectivity.State
+	)
+	observed := func() []connectivity.State {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return append([]connectivity.State(nil), states...)
+	}
+	contains := func(state connectivity.State) func() bool {
+		return func() bool {
+			for _, s := range observed() {
+				if s == state {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure(), grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff:           backoff.Config{BaseDelay: 10 * time.Millisecond, Multiplier: 1, MaxDelay: 10 * time.Millisecond},
+		MinConnectTimeout: 100 * time.Millisecond,
+	}))
+	require.NoError(t, err)
+
+	grpcclient.WatchConnectionState(conn, addr, func(stateAddr string, state connectivity.State) {
+		assert.Equal(t, addr, stateAddr)
+		mtx.Lock()
+		states = append(states, state)
+		mtx.Unlock()
+	})
+
+	require.Eventually(t, contains(connectivity.Ready), 5*time.Second, 10*time.Millisecond)
+
+	// Take the backend down.
+	server.Stop()
+	require.Eventually(t, contains(connectivity.TransientFailure), 5*time.Second, 10*time.Millisecond)
+
+	// Bring the backend back up on the same address.
+	mtx.Lock()
+	states = nil
+	mtx.Unlock()
+	listener, err = net.Listen("tcp", addr)
+	require.NoError(t, err)
+	server = startServer(listener)
+	defer server.Stop()
+	require.Eventually(t, contains(connectivity.Ready), 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, conn.Close())
+	require.Eventually(t, contains(connectivity.Shutdown), 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConfigDialWithOnStateChange(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	states := make(chan connectivity.State, 10)
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 1 << 20,
+		MaxSendMsgSize: 1 << 20,
+		OnStateChange: func(addr string, state connectivity.State) {
+			assert.Equal(t, listener.Addr().String(), addr)
+			states <- state
+		},
+	}
+	conn, err := cfg.Dial(listener.Addr().String(), nil, nil)
+	require.NoError(t, err)
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	var observed []connectivity.State
+	for state := range states {
+		observed = append(observed, state)
+		if state == connectivity.Shutdown {
+			break
+		}
+	}
+	assert.Contains(t, observed, connectivity.Ready)
+}
@@ -0,0 +1,66 @@
+package grpcclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// TokenRefresher returns a new bearer token and the time it expires at.
+type TokenRefresher func(ctx context.Context) (token string, expiry time.Time, err error)
+
+// RefreshingTokenCredentials is a credentials.PerRPCCredentials sending a bearer token
+// with each call. The token is cached, and refreshed by the first call issued when it's
+// about to expire: concurrent calls wait for the refresh instead of triggering their own.
+type RefreshingTokenCredentials struct {
+	refresh       TokenRefresher
+	refreshBefore time.Duration
+
+	mtx    sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+var _ credentials.PerRPCCredentials = (*RefreshingTokenCredentials)(nil)
+
+// NewRefreshingTokenCredentials creates RefreshingTokenCredentials getting tokens from
+// refresh, refreshing them refreshBefore their expiry.
+func NewRefreshingTokenCredentials(refresh TokenRefresher, refreshBefore time.Duration) *RefreshingTokenCredentials {
+	return &RefreshingTokenCredentials{
+		refresh:       refresh,
+		refreshBefore: refreshBefore,
+	}
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials.
+func (c *RefreshingTokenCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := c.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"authorization": "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials. Tokens are never
+// sent over insecure connections.
+func (c *RefreshingTokenCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+func (c *RefreshingTokenCredentials) getToken(ctx context.Context) (string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.token != "" && time.Now().Add(c.refreshBefore).Before(c.expiry) {
+		return c.token, nil
+	}
+
+	token, expiry, err := c.refresh(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.token, c.expiry = token, expiry
+	return token, nil
+}
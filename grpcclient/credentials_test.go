@@ -0,0 +1,249 @@
+package grpcclient_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestRefreshingTokenCredentials(t *testing.T) {
+	const refreshBefore = 100 * time.Millisecond
+
+	refreshes := atomic.NewInt32(0)
+	validity := atomic.NewDuration(time.Hour)
+	creds := grpcclient.NewRefreshingTokenCredentials(func(ctx context.Context) (string, time.Time, error) {
+		n := refreshes.Inc()
+		// Give concurrent calls the chance to find the token expired.
+		time.Sleep(20 * time.Millisecond)
+		return []string{"", "first", "second"}[n], time.Now().Add(validity.Load()), nil
+	}, refreshBefore)
+	assert.True(t, creds.RequireTransportSecurity())
+
+	// concurrentCalls returns the tokens sent by concurrent calls.
+	concurrentCalls := func() map[string]int {
+		var (
+			mtx    sync.Mutex
+			tokens = map[string]int{}
+			wg     sync.WaitGroup
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				md, err := creds.GetRequestMetadata(context.Background())
+				assert.NoError(t, err)
+				mtx.Lock()
+				tokens[md["authorization"]]++
+				mtx.Unlock()
+			}()
+		}
+		wg.Wait()
+		return tokens
+	}
+
+	// The token is fetched once, and cached. It's about to expire after 50ms.
+	validity.Store(refreshBefore + 50*time.Millisecond)
+	assert.Equal(t, map[string]int{"Bearer first": 10}, concurrentCalls())
+	assert.Equal(t, int32(1), refreshes.Load())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, map[string]int{"Bearer second": 10}, concurrentCalls())
+	assert.Equal(t, int32(2), refreshes.Load())
+}
+
+type staticCredentials map[string]string
+
+func (c staticCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return c, nil
+}
+
+func (c staticCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// securedCredentials are static credentials requiring transport security.
+type securedCredentials struct {
+	staticCredentials
+}
+
+func (c securedCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+func TestDialOptionWithPerRPCCredentials(t *testing.T) {
+	var received metadata.MD
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		received, _ = metadata.FromIncomingContext(ctx)
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:    1024,
+		MaxSendMsgSize:    1024,
+		PerRPCCredentials: staticCredentials{"authorization": "Bearer token"},
+	}
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+
+	conn, err := grpc.Dial("bufconn", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bearer token"}, received.Get("authorization"))
+}
+
+// fakeBundle provides insecure transport credentials and static per-RPC credentials.
+type fakeBundle struct {
+	perRPC         staticCredentials
+	transportCalls *atomic.Int32
+}
+
+func (b fakeBundle) TransportCredentials() credentials.TransportCredentials {
+	b.transportCalls.Inc()
+	return insecure.NewCredentials()
+}
+
+func (b fakeBundle) PerRPCCredentials() credentials.PerRPCCredentials {
+	return b.perRPC
+}
+
+func (b fakeBundle) NewWithMode(string) (credentials.Bundle, error) {
+	return b, nil
+}
+
+func TestDialOptionWithCredentialsBundle(t *testing.T) {
+	var received metadata.MD
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		received, _ = metadata.FromIncomingContext(ctx)
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	bundle := fakeBundle{perRPC: staticCredentials{"authorization": "Bearer derived"}, transportCalls: atomic.NewInt32(0)}
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:    1024,
+		MaxSendMsgSize:    1024,
+		CredentialsBundle: bundle,
+	}
+	require.NoError(t, cfg.Validate(nil))
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+
+	conn, err := grpc.Dial("bufconn", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Bearer derived"}, received.Get("authorization"))
+	assert.Greater(t, bundle.transportCalls.Load(), int32(0))
+}
+
+func TestConfigValidateCredentialsBundle(t *testing.T) {
+	bundle := fakeBundle{transportCalls: atomic.NewInt32(0)}
+
+	for name, test := range map[string]struct {
+		cfg         grpcclient.Config
+		expectedErr string
+	}{
+		"bundle only": {cfg: grpcclient.Config{CredentialsBundle: bundle}},
+		"with credentials type": {
+			cfg:         grpcclient.Config{CredentialsBundle: bundle, CredentialsType: grpcclient.CredentialsTypeTLS},
+			expectedErr: "the credentials bundle can't be used with a credentials type or TLS enabled, since it provides the transport credentials",
+		},
+		"with TLS enabled": {
+			cfg:         grpcclient.Config{CredentialsBundle: bundle, TLSEnabled: true},
+			expectedErr: "the credentials bundle can't be used with a credentials type or TLS enabled, since it provides the transport credentials",
+		},
+		"with per-RPC credentials": {
+			cfg:         grpcclient.Config{CredentialsBundle: bundle, PerRPCCredentials: staticCredentials{}},
+			expectedErr: "the credentials bundle can't be used with per-RPC credentials, since it provides them",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := test.cfg.Validate(nil)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectedErr)
+			}
+		})
+	}
+}
+
+func TestConfigValidatePerRPCCredentialsTransportSecurity(t *testing.T) {
+	const expectedErr = "the per-RPC credentials require transport security, but the connections are insecure: set the credentials type to tls or alts, or enable TLS"
+
+	for name, test := range map[string]struct {
+		cfg         grpcclient.Config
+		expectedErr string
+	}{
+		"insecure credentials over insecure connections": {
+			cfg: grpcclient.Config{PerRPCCredentials: staticCredentials{}},
+		},
+		"secured credentials over insecure connections": {
+			cfg:         grpcclient.Config{PerRPCCredentials: securedCredentials{}},
+			expectedErr: expectedErr,
+		},
+		"secured credentials with the insecure credentials type": {
+			cfg:         grpcclient.Config{PerRPCCredentials: securedCredentials{}, CredentialsType: grpcclient.CredentialsTypeInsecure},
+			expectedErr: expectedErr,
+		},
+		"refreshing token credentials over insecure connections": {
+			cfg:         grpcclient.Config{PerRPCCredentials: &grpcclient.RefreshingTokenCredentials{}},
+			expectedErr: expectedErr,
+		},
+		"secured credentials with TLS enabled": {
+			cfg: grpcclient.Config{PerRPCCredentials: securedCredentials{}, TLSEnabled: true},
+		},
+		"secured credentials with the TLS credentials type": {
+			cfg: grpcclient.Config{PerRPCCredentials: securedCredentials{}, CredentialsType: grpcclient.CredentialsTypeTLS},
+		},
+		"secured credentials with the ALTS credentials type": {
+			cfg: grpcclient.Config{PerRPCCredentials: securedCredentials{}, CredentialsType: grpcclient.CredentialsTypeALTS},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := test.cfg.Validate(nil)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.expectedErr)
+			}
+		})
+	}
+}
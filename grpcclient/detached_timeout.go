@@ -0,0 +1,41 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// NewDetachedTimeout creates a UnaryClientInterceptor which decouples calls to the given
+// methods from the caller's context lifetime: the call ignores the caller's deadline and
+// cancellation, and is instead bounded by a fresh timeout. Values carried by the caller's
+// context (e.g. outgoing metadata and tracing spans) are preserved. This is meant for
+// best-effort, fire-and-forget calls. Calls to other methods are left untouched.
+func NewDetachedTimeout(timeout time.Duration, methods []string) grpc.UnaryClientInterceptor {
+	allowed := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		allowed[m] = struct{}{}
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := allowed[method]; !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		ctx, cancel := context.WithTimeout(detachedContext{parent: ctx}, timeout)
+		defer cancel()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// detachedContext carries the values of its parent, but never gets canceled and
+// has no deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,53 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestDetachedTimeout(t *testing.T) {
+	const timeout = 100 * time.Millisecond
+
+	// The invoker waits for the call context to be done and reports the outcome.
+	var (
+		callErr error
+		elapsed time.Duration
+		md      metadata.MD
+	)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		start := time.Now()
+		md, _ = metadata.FromOutgoingContext(ctx)
+		<-ctx.Done()
+		callErr, elapsed = ctx.Err(), time.Since(start)
+		return callErr
+	}
+
+	interceptor := grpcclient.NewDetachedTimeout(timeout, []string{"/test/detached"})
+
+	t.Run("allowed method survives caller cancellation but respects the timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(metadata.AppendToOutgoingContext(context.Background(), "tenant", "1"), time.Hour)
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		err := interceptor(ctx, "/test/detached", nil, nil, &grpc.ClientConn{}, invoker)
+		require.Equal(t, context.DeadlineExceeded, err)
+		assert.GreaterOrEqual(t, int64(elapsed), int64(timeout))
+		assert.Equal(t, []string{"1"}, md.Get("tenant"))
+	})
+
+	t.Run("other methods keep the caller context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		err := interceptor(ctx, "/test/other", nil, nil, &grpc.ClientConn{}, invoker)
+		require.Equal(t, context.Canceled, err)
+		assert.Less(t, int64(elapsed), int64(timeout))
+	})
+}
@@ -0,0 +1,58 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/grafana/dskit/multierror"
+)
+
+// DialFailover dials addrs in order, and returns the connection to the first address
+// which becomes ready within readyTimeout, e.g. a primary followed by its secondaries.
+// The connections to the addresses which didn't become ready are closed. opts are passed
+// to grpc.Dial, e.g. the ones returned by Config.DialOption. If no address becomes
+// ready, the returned error includes the failure of each address.
+func DialFailover(ctx context.Context, addrs []string, readyTimeout time.Duration, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no address to dial")
+	}
+
+	errs := multierror.New()
+	for _, addr := range addrs {
+		conn, err := grpc.DialContext(ctx, addr, opts...)
+		if err == nil {
+			err = waitForReady(ctx, conn, readyTimeout)
+			if err == nil {
+				return conn, nil
+			}
+			_ = conn.Close()
+		}
+		errs.Add(fmt.Errorf("%s: %w", addr, err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, fmt.Errorf("failed to connect to any of the addresses: %w", errs.Err())
+}
+
+// waitForReady waits until conn is ready, for at most timeout.
+func waitForReady(ctx context.Context, conn *grpc.ClientConn, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection not ready after %s, last state: %s", timeout, state)
+		}
+	}
+}
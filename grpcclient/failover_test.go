@@ -0,0 +1,61 @@
+package grpcclient_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+// deadAddress returns an address nothing is listening on.
+func deadAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	require.NoError(t, listener.Close())
+	return addr
+}
+
+func TestDialFailover(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	cfg := grpcclient.Config{MaxRecvMsgSize: 1024, MaxSendMsgSize: 1024}
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+
+	t.Run("dead primary fails over to the healthy secondary", func(t *testing.T) {
+		healthy := listener.Addr().String()
+		conn, err := grpcclient.DialFailover(context.Background(), []string{deadAddress(t), healthy}, 200*time.Millisecond, opts...)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		assert.Equal(t, healthy, conn.Target())
+		assert.Equal(t, connectivity.Ready, conn.GetState())
+		_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		assert.NoError(t, err)
+	})
+
+	t.Run("all addresses dead", func(t *testing.T) {
+		first, second := deadAddress(t), deadAddress(t)
+		_, err := grpcclient.DialFailover(context.Background(), []string{first, second}, 100*time.Millisecond, opts...)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to connect to any of the addresses: 2 errors: "+first+": connection not ready after 100ms")
+		assert.Contains(t, err.Error(), "; "+second+": connection not ready after 100ms")
+	})
+}
@@ -2,18 +2,26 @@ package grpcclient
 
 import (
 	"flag"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/go-kit/log"
-	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
 	"google.golang.org/grpc/encoding/gzip"
-	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/stats"
 
 	"github.com/grafana/dskit/backoff"
 	"github.com/grafana/dskit/crypto/tls"
-	"github.com/grafana/dskit/grpcencoding/snappy"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/dskit/grpcencoding"
+	"github.com/grafana/dskit/grpcencoding/checksum"
 )
 
 // Config for a gRPC client.
@@ -24,11 +32,206 @@ type Config struct {
 	RateLimit       float64 `yaml:"rate_limit"`
 	RateLimitBurst  int     `yaml:"rate_limit_burst"`
 
-	BackoffOnRatelimits bool           `yaml:"backoff_on_ratelimits"`
-	BackoffConfig       backoff.Config `yaml:"backoff_config"`
+	// RateLimitDisabled turns off the client side rate limiting configured by RateLimit,
+	// RateLimiter and PerTenantRateLimit, without clearing the configured limits.
+	RateLimitDisabled bool `yaml:"rate_limit_disabled"`
 
+	// RateLimiter, if set, rate limits the calls instead of RateLimit and RateLimitBurst,
+	// so that the limit can be changed at runtime.
+	RateLimiter *RateLimiter `yaml:"-"`
+
+	// PerTenantRateLimit, if set, rate limits the calls of each tenant independently, see
+	// NewPerTenantRateLimiter.
+	PerTenantRateLimit           float64 `yaml:"per_tenant_rate_limit"`
+	PerTenantRateLimitBurst      int     `yaml:"per_tenant_rate_limit_burst"`
+	PerTenantRateLimitMaxTenants int     `yaml:"per_tenant_rate_limit_max_tenants"`
+
+	AdaptiveCompression          bool          `yaml:"adaptive_compression"`
+	CompressionDeadlineSkipBelow time.Duration `yaml:"compression_deadline_skip_below"`
+	MinCompressSize              int           `yaml:"min_compress_size"`
+	CompressionNegotiation       bool          `yaml:"compression_negotiation"`
+	ServerPreferredCompression   bool          `yaml:"server_preferred_compression"`
+	MaxStreamLifetime            time.Duration `yaml:"max_stream_lifetime"`
+
+	// PerMethodCompression overrides the compression for specific methods, identified by
+	// their full name (e.g. "/package.Service/Method"). An empty value disables
+	// compression for the method. It can only be set in the YAML config.
+	PerMethodCompression map[string]string `yaml:"per_method_compression"`
+
+	// AcceptCompression are the compressions advertised to the server as accepted for
+	// responses, on the requests sent uncompressed, see NewAcceptCompression. Requests are
+	// compressed according to GRPCCompression, independently. The advertisement is
+	// advisory: grpc-go servers ignore it, and compress the responses like the requests.
+	AcceptCompression flagext.StringSliceCSV `yaml:"accept_compression"`
+
+	// CompressorSelector, if set, chooses the compressor of each call, overriding the
+	// compression configured for the client, also per method.
+	CompressorSelector CompressorSelector `yaml:"-"`
+
+	// Authority overrides the :authority pseudo-header sent on every request. Load
+	// balancers and service meshes that route HTTP/2 traffic on authority will see
+	// this value instead of the dial target. It is independent from TLS ServerName, and
+	// only applies to insecure connections: gRPC ignores it with transport credentials.
+	Authority string `yaml:"authority"`
+
+	DisableProxy bool `yaml:"disable_proxy"`
+
+	// AddressFamily, if set, forces the network used to dial the server: "tcp4" for IPv4
+	// or "tcp6" for IPv6, instead of trying the addresses of both families. It gives a
+	// deterministic behavior in misconfigured dual-stack environments. Proxies are
+	// ignored when it's set.
+	AddressFamily string `yaml:"address_family"`
+
+	// DisableNagle disables Nagle's algorithm (TCP_NODELAY) on the connections to the
+	// server, so that small messages are sent right away instead of being delayed to be
+	// coalesced with the next ones: it lowers the latency of small requests, at the cost
+	// of more packets, and a lower throughput, when sending many of them. Go already
+	// disables it on the connections it dials, this makes it explicit whatever the
+	// defaults. Proxies are ignored when it's set.
+	DisableNagle bool `yaml:"disable_nagle"`
+
+	// DisableHealthCheck disables the client-side health checking performed by load
+	// balancers (e.g. round_robin) configured with a health check service config.
+	// Backends are then considered healthy as long as they are connected, which is
+	// useful when they don't implement the gRPC health service.
+	DisableHealthCheck bool `yaml:"disable_health_check"`
+
+	// ServiceConfigJSON is the default service config of the connection, used when the
+	// resolver doesn't provide any. See https://github.com/grpc/grpc/blob/master/doc/service_config.md.
+	// gRPC caps the maxAttempts of retry policies at 5. The cap can't be changed, since
+	// grpc.WithMaxCallAttempts isn't available with the gRPC version dskit depends on.
+	ServiceConfigJSON string `yaml:"service_config_json"`
+
+	// ReturnConnectionError makes dials block until the connection is ready, like
+	// grpc.WithBlock, and fail with the last connection error (e.g. a TLS handshake
+	// failure) instead of a bare timeout when the context passed to grpc.DialContext
+	// expires first. The context must have a deadline, otherwise dialing an unreachable
+	// server blocks forever.
+	ReturnConnectionError bool `yaml:"return_connection_error"`
+
+	// DefaultWaitForReady makes calls wait for the connection to be ready, e.g. during
+	// brief reconnects, instead of failing fast with Unavailable. The tradeoff is that
+	// calls to an unreachable server block until their deadline expires.
+	DefaultWaitForReady bool `yaml:"default_wait_for_ready"`
+
+	// Interceptors are the names of unary interceptors registered with
+	// RegisterUnaryInterceptor, added in the given order after the built-in ones.
+	Interceptors flagext.StringSliceCSV `yaml:"interceptors"`
+
+	// UserInterceptorsFirst places the interceptors passed to DialOption before the
+	// built-in ones, instead of after them. They then run once per call, outside the rate
+	// limiter and the retries: their timings include the rate limiting and backoff waits,
+	// and they see the final error of a call rather than the error of each attempt. The
+	// required metadata is checked last either way.
+	UserInterceptorsFirst bool `yaml:"user_interceptors_first"`
+
+	// RequiredMetadataKeys are outgoing metadata keys (e.g. X-Scope-OrgID) which must
+	// be set on every call. Calls missing any of them fail without being sent.
+	RequiredMetadataKeys flagext.StringSliceCSV `yaml:"required_metadata_keys"`
+
+	BackoffOnRatelimits  bool           `yaml:"backoff_on_ratelimits"`
+	BackoffShared        bool           `yaml:"backoff_shared"`
+	BackoffOnUnavailable bool           `yaml:"backoff_on_unavailable"`
+	BackoffConfig        backoff.Config `yaml:"backoff_config"`
+
+	// BackoffOnReconnect uses BackoffConfig as the gRPC reconnection backoff instead of
+	// the gRPC default one, see backoff.Config.ToGRPCBackoffConfig.
+	BackoffOnReconnect bool `yaml:"backoff_on_reconnect"`
+
+	// BackoffRetryCountMetadata sends the number of retries made by the backoff on rate
+	// limits in the metadata of each attempt, see NewRetryCount.
+	BackoffRetryCountMetadata bool `yaml:"backoff_retry_count_metadata"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+
+	// DefaultCallTimeout and MaxCallTimeout bound the duration of unary calls, see
+	// NewCallTimeout. EffectiveDeadline returns the deadline a call gets with them.
+	DefaultCallTimeout time.Duration `yaml:"default_call_timeout"`
+	MaxCallTimeout     time.Duration `yaml:"max_call_timeout"`
+
+	// CredentialsType is the transport security of the connections: CredentialsTypeTLS,
+	// CredentialsTypeInsecure or CredentialsTypeALTS. If empty, TLS is used when
+	// TLSEnabled is set, otherwise connections are insecure.
+	CredentialsType string `yaml:"credentials_type"`
+
+	// Deprecated: use CredentialsType instead.
 	TLSEnabled bool             `yaml:"tls_enabled"`
 	TLS        tls.ClientConfig `yaml:",inline"`
+
+	// PerRPCCredentials, if set, attaches credentials to every call, for example
+	// RefreshingTokenCredentials. Credentials requiring transport security can't be used
+	// over insecure connections.
+	PerRPCCredentials credentials.PerRPCCredentials `yaml:"-"`
+
+	// CredentialsBundle, if set, provides both the transport and the per-RPC credentials,
+	// for auth schemes coupling them, e.g. tokens derived from the mTLS identity. It can't
+	// be used with CredentialsType, TLSEnabled or PerRPCCredentials.
+	CredentialsBundle credentials.Bundle `yaml:"-"`
+
+	// KeepaliveTime is the time without activity after which the client pings the server,
+	// and KeepaliveTimeout how long it waits for the ping to be acknowledged before
+	// closing the connection. They default to 20 and 10 seconds. The keepalive time
+	// can't be lower than 10 seconds, the minimum allowed by gRPC, and lower values are
+	// raised to it. Servers must accept pings this often, see ServerEnforcementPolicyFor.
+	KeepaliveTime    time.Duration `yaml:"keepalive_time"`
+	KeepaliveTimeout time.Duration `yaml:"keepalive_timeout"`
+
+	// IdleTimeout, if set, replaces the keepalive time and timeout with ones
+	// derived from it, so that a connection without activity from the server for this
+	// long is closed. gRPC doesn't allow sending keepalive pings more often than every
+	// 10 seconds, so the effective timeout can't be lower than 15 seconds. It's unrelated
+	// to the channel idleness of grpc.WithIdleTimeout, which requires gRPC 1.56 or later
+	// and isn't available with the gRPC version dskit depends on.
+	IdleTimeout  time.Duration `yaml:"idle_timeout"`
+	LogKeepalive bool          `yaml:"log_keepalive"`
+
+	// KeepaliveJitter, if set, randomizes the keepalive time of each connection within
+	// this range around its value, so that clients started together don't ping in sync.
+	KeepaliveJitter time.Duration `yaml:"keepalive_jitter"`
+
+	// StreamingClient selects the StreamKeepalive parameters instead of the keepalive
+	// ones, for clients used primarily for streaming. A connection can't have two
+	// keepalive policies, so that it's selected per client: the unary calls of a
+	// streaming client use the StreamKeepalive parameters too, and clients with both
+	// needs should use two Configs.
+	StreamingClient bool                  `yaml:"streaming_client"`
+	StreamKeepalive StreamKeepaliveConfig `yaml:"stream_keepalive"`
+
+	// ChannelLabel is a logical name (e.g. "ingester-client") tagging the connections, so
+	// that they can be told apart in logs and by stats handlers, which can read it with
+	// ChannelLabelFromContext.
+	ChannelLabel string `yaml:"channel_label"`
+
+	// InstrumentSizes tracks the wire size of the messages sent and received with the
+	// grpc_client_request_size_bytes and grpc_client_response_size_bytes metrics,
+	// registered with Registerer. It has no effect if Registerer is not set.
+	InstrumentSizes bool `yaml:"instrument_sizes"`
+
+	// RecvSizeWarnThreshold, if set, logs a warning when a received message is larger than
+	// this fraction of MaxRecvMsgSize, at most once per minute, to give time to raise the
+	// limit before messages are rejected.
+	RecvSizeWarnThreshold float64 `yaml:"recv_size_warn_threshold"`
+
+	// AutoTuneRecvSize, if set, makes AutoTune lower MaxRecvMsgSize so that
+	// MaxConcurrentRequests messages of that size fit in half of the memory available to
+	// the process, read from its cgroup limit or the host memory.
+	AutoTuneRecvSize      bool `yaml:"auto_tune_recv_size"`
+	MaxConcurrentRequests int  `yaml:"max_concurrent_requests"`
+
+	// Logger is used for debug logging of connection events. Defaults to a no-op logger.
+	Logger log.Logger `yaml:"-"`
+
+	// Registerer, if set, is used to register the metrics of the client interceptors.
+	// It can be shared by the connections of multiple clients.
+	Registerer prometheus.Registerer `yaml:"-"`
+
+	// ResolverBuilder, if set, is used to resolve the dial target of this connection
+	// only, instead of registering the resolver globally with resolver.Register.
+	ResolverBuilder resolver.Builder `yaml:"-"`
+
+	// OnStateChange, if set, is called every time the state of a connection created by
+	// Dial changes, see WatchConnectionState.
+	OnStateChange func(addr string, state connectivity.State) `yaml:"-"`
 }
 
 // RegisterFlags registers flags.
@@ -39,28 +242,220 @@ func (cfg *Config) RegisterFlags(f *flag.FlagSet) {
 // RegisterFlagsWithPrefix registers flags with prefix.
 func (cfg *Config) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
 	f.IntVar(&cfg.MaxRecvMsgSize, prefix+".grpc-max-recv-msg-size", 100<<20, "gRPC client max receive message size (bytes).")
-	f.IntVar(&cfg.MaxSendMsgSize, prefix+".grpc-max-send-msg-size", 16<<20, "gRPC client max send message size (bytes).")
-	f.StringVar(&cfg.GRPCCompression, prefix+".grpc-compression", "", "Use compression when sending messages. Supported values are: 'gzip', 'snappy' and '' (disable compression)")
+	f.IntVar(&cfg.MaxSendMsgSize, prefix+".grpc-max-send-msg-size", 16<<20, "gRPC client max send message size (bytes). The limit applies to compressed messages, which may be larger than uncompressed ones.")
+	f.StringVar(&cfg.GRPCCompression, prefix+".grpc-compression", "", "Use compression when sending messages. Supported values are: 'gzip', 'snappy', 'snappy-crc' (snappy with checksum verification) and '' (disable compression)")
+	f.BoolVar(&cfg.AdaptiveCompression, prefix+".grpc-adaptive-compression", false, "Choose the compression (gzip, snappy or none) to use for each method based on the compression ratio measured on its first requests. The configured compression is used until then.")
+	f.Var(&cfg.AcceptCompression, prefix+".grpc-accept-compression", "Comma-separated list of compressions advertised to the server as accepted for responses, on the requests sent uncompressed, independently from the compression used when sending messages. The server may ignore it: gRPC Go servers compress responses like requests. Supported values are: 'gzip', 'snappy' and 'snappy-crc'.")
+	f.IntVar(&cfg.MinCompressSize, prefix+".grpc-min-compress-size", 0, "Send the requests smaller than this size in bytes uncompressed. The size is advertised to the server, and the larger of the two is used once the server advertises its own. 0 means all requests are compressed.")
+	f.DurationVar(&cfg.CompressionDeadlineSkipBelow, prefix+".grpc-compression-deadline-skip-below", 0, "Skip compression for calls whose remaining deadline is below this value, to save the time spent compressing. 0 means compression is never skipped.")
+	f.BoolVar(&cfg.ServerPreferredCompression, prefix+".grpc-server-preferred-compression", false, "Switch the compression of calls to the one hinted by the server in the preferred-encoding response trailer, if supported.")
+	f.BoolVar(&cfg.CompressionNegotiation, prefix+".grpc-compression-negotiation", false, "Send calls uncompressed once the server is found not to support their compressor, instead of failing them.")
+	f.DurationVar(&cfg.MaxStreamLifetime, prefix+".grpc-max-stream-lifetime", 0, "Maximum time a stream can stay open before being canceled, forcing the caller to re-establish it. 0 means no limit.")
 	f.Float64Var(&cfg.RateLimit, prefix+".grpc-client-rate-limit", 0., "Rate limit for gRPC client; 0 means disabled.")
 	f.IntVar(&cfg.RateLimitBurst, prefix+".grpc-client-rate-limit-burst", 0, "Rate limit burst for gRPC client.")
+	f.BoolVar(&cfg.RateLimitDisabled, prefix+".grpc-client-rate-limit-disabled", false, "Disable the gRPC client rate limits. The configured rate limits are then kept but not enforced, while backoff on rate limits still applies.")
+	f.Float64Var(&cfg.PerTenantRateLimit, prefix+".grpc-client-per-tenant-rate-limit", 0., "Per-tenant rate limit for gRPC client, applied to calls with the X-Scope-OrgID metadata. Calls without it are rate limited by the gRPC client rate limit. 0 means disabled.")
+	f.IntVar(&cfg.PerTenantRateLimitBurst, prefix+".grpc-client-per-tenant-rate-limit-burst", 0, "Per-tenant rate limit burst for gRPC client.")
+	f.IntVar(&cfg.PerTenantRateLimitMaxTenants, prefix+".grpc-client-per-tenant-rate-limit-max-tenants", defaultPerTenantRateLimitMaxTenants, "Maximum number of tenants whose rate limiter is kept. The least recently seen tenants are evicted first.")
+	f.StringVar(&cfg.Authority, prefix+".grpc-authority", "", "Override the :authority header sent to the server. Useful when requests are routed on authority by a load balancer or service mesh. If empty, the dial target is used. Only applies to insecure connections.")
+	f.BoolVar(&cfg.DisableProxy, prefix+".grpc-disable-proxy", false, "Ignore the proxy environment variables (e.g. HTTPS_PROXY) and always dial the server directly.")
+	f.StringVar(&cfg.AddressFamily, prefix+".grpc-address-family", "", "Force the network used to dial the server. Supported values are: 'tcp4' (IPv4 only), 'tcp6' (IPv6 only) and '' (both, preferring the first resolved address).")
+	f.BoolVar(&cfg.DisableNagle, prefix+".grpc-disable-nagle", false, "Disable Nagle's algorithm (TCP_NODELAY) on the connections to the server, lowering the latency of small requests at the cost of more packets sent.")
+	f.BoolVar(&cfg.DisableHealthCheck, prefix+".grpc-disable-health-check", false, "Disable the client-side health checking of the load balancer, considering backends healthy as long as they are connected.")
+	f.StringVar(&cfg.ServiceConfigJSON, prefix+".grpc-service-config-json", "", "Default gRPC service config in JSON format, used when the resolver doesn't provide any. If empty, no default service config is used.")
+	f.BoolVar(&cfg.ReturnConnectionError, prefix+".grpc-return-connection-error", false, "Block dials until the connection is ready, and fail them with the last connection error, e.g. a TLS handshake failure, instead of a timeout.")
+	f.BoolVar(&cfg.DefaultWaitForReady, prefix+".grpc-default-wait-for-ready", false, "Make calls wait for the connection to be ready instead of failing fast when it's not. Calls to an unreachable server then block until their deadline expires.")
+	f.Var(&cfg.Interceptors, prefix+".grpc-interceptors", "Comma-separated list of names of additional interceptors, registered by the application, to apply to unary calls in the given order.")
+	f.BoolVar(&cfg.UserInterceptorsFirst, prefix+".grpc-user-interceptors-first", false, "Run the interceptors set by the application before the built-in ones, so that they time the whole call, including the rate limiting and backoff waits.")
+	f.Var(&cfg.RequiredMetadataKeys, prefix+".grpc-required-metadata-keys", "Comma-separated list of outgoing metadata keys (e.g. X-Scope-OrgID) which must be set on every call. Calls missing any of them fail with InvalidArgument without being sent to the server.")
 	f.BoolVar(&cfg.BackoffOnRatelimits, prefix+".backoff-on-ratelimits", false, "Enable backoff and retry when we hit ratelimits.")
-	f.BoolVar(&cfg.TLSEnabled, prefix+".tls-enabled", cfg.TLSEnabled, "Enable TLS in the GRPC client. This flag needs to be enabled when any other TLS flag is set. If set to false, insecure connection to gRPC server will be used.")
+	f.BoolVar(&cfg.BackoffRetryCountMetadata, prefix+".backoff-retry-count-metadata", false, "Send the number of retries made so far by the backoff on rate limits in the x-client-retry-count metadata of each attempt, so that the server can tell how many retries a call took.")
+	f.BoolVar(&cfg.BackoffOnUnavailable, prefix+".backoff-on-unavailable", false, "Enable backoff and retry when the server is unavailable, reconnecting immediately before each retry instead of waiting for the gRPC reconnection backoff.")
+	f.BoolVar(&cfg.BackoffOnReconnect, prefix+".backoff-on-reconnect", false, "Use the backoff config as the gRPC reconnection backoff, instead of the gRPC default backoff of 1s up to 2m.")
+	f.BoolVar(&cfg.BackoffShared, prefix+".backoff-shared", false, "Share the backoff delay across calls instead of starting every call from the minimum delay. The delay is reset when any call succeeds.")
+	f.DurationVar(&cfg.KeepaliveTime, prefix+".grpc-keepalive-time", defaultKeepaliveTime, "Time without activity after which the client pings the server to check the connection is alive. Values lower than 10s, the minimum allowed by gRPC, are raised to 10s. The server keepalive enforcement policy must allow pings this often.")
+	f.DurationVar(&cfg.KeepaliveTimeout, prefix+".grpc-keepalive-timeout", defaultKeepaliveTimeout, "Time the client waits for a keepalive ping to be acknowledged before closing the connection.")
+	f.DurationVar(&cfg.IdleTimeout, prefix+".grpc-idle-timeout", 0, "Close connections with no activity from the server for this long, deriving the keepalive ping time and timeout from it. 0 means the keepalive ping time and timeout are used.")
+	f.StringVar(&cfg.ChannelLabel, prefix+".grpc-channel-label", "", "Logical name tagging the client connections, included in connection logs to attribute them to a client.")
+	f.BoolVar(&cfg.InstrumentSizes, prefix+".grpc-instrument-sizes", false, "Track the size on the wire of the messages sent and received by the client.")
+	f.Float64Var(&cfg.RecvSizeWarnThreshold, prefix+".grpc-recv-size-warn-threshold", 0, "Log a warning when a received message is larger than this fraction (between 0 and 1) of the max receive message size. 0 means disabled.")
+	f.BoolVar(&cfg.AutoTuneRecvSize, prefix+".grpc-auto-tune-recv-size", false, "Lower the max receive message size at startup, so that the messages received by the expected number of concurrent requests fit in half of the available memory.")
+	f.IntVar(&cfg.MaxConcurrentRequests, prefix+".grpc-max-concurrent-requests", 100, "Expected number of concurrent requests, used to auto-tune the max receive message size.")
+	f.DurationVar(&cfg.KeepaliveJitter, prefix+".grpc-keepalive-jitter", 0, "Randomize the keepalive ping time of each connection by up to this duration, more or less, to spread the pings of clients started together. 0 means no jitter.")
+	f.BoolVar(&cfg.StreamingClient, prefix+".grpc-streaming-client", false, "Use the stream keepalive time and timeout instead of the keepalive ones, for clients used primarily for streaming. It applies to all the calls of the client, since a connection can't have two keepalive policies.")
+	f.BoolVar(&cfg.LogKeepalive, prefix+".grpc-client-log-keepalive", false, "Log connection establishment and closure (e.g. due to keepalive timeouts or GOAWAY) at debug level, including the remote address.")
+	f.BoolVar(&cfg.TLSEnabled, prefix+".tls-enabled", cfg.TLSEnabled, "Enable TLS in the GRPC client. This flag needs to be enabled when any other TLS flag is set. If set to false, insecure connection to gRPC server will be used. Deprecated: use -"+prefix+".grpc-credentials-type=tls instead.")
+	f.StringVar(&cfg.CredentialsType, prefix+".grpc-credentials-type", "", "Transport security of the gRPC client connections. Supported values are: 'tls', 'insecure' and 'alts' (Application Layer Transport Security, available on Google Cloud). If empty, TLS is used if enabled, otherwise insecure connections.")
 
 	cfg.BackoffConfig.RegisterFlagsWithPrefix(prefix, f)
+	cfg.CircuitBreaker.RegisterFlagsWithPrefix(prefix, f)
+	cfg.StreamKeepalive.RegisterFlagsWithPrefix(prefix, f)
+	f.DurationVar(&cfg.DefaultCallTimeout, prefix+".grpc-default-call-timeout", 0, "Timeout of the unary calls issued without a deadline. 0 means calls without a deadline have no timeout, unless the max call timeout is set.")
+	f.DurationVar(&cfg.MaxCallTimeout, prefix+".grpc-max-call-timeout", 0, "Maximum timeout of unary calls: later deadlines are moved earlier. 0 means no maximum.")
 
 	cfg.TLS.RegisterFlagsWithPrefix(prefix, f)
 }
 
 func (cfg *Config) Validate(log log.Logger) error {
-	switch cfg.GRPCCompression {
-	case gzip.Name, snappy.Name, "":
-		// valid
+	if err := cfg.validateCompression(); err != nil {
+		return err
+	}
+	for _, name := range cfg.Interceptors {
+		if _, err := registeredUnaryInterceptor(name); err != nil {
+			return err
+		}
+	}
+	if cfg.AutoTuneRecvSize && cfg.MaxConcurrentRequests <= 0 {
+		return fmt.Errorf("gRPC client max concurrent requests must be positive to auto-tune the max receive message size, got %d", cfg.MaxConcurrentRequests)
+	}
+	if cfg.RecvSizeWarnThreshold < 0 || cfg.RecvSizeWarnThreshold > 1 {
+		return fmt.Errorf("gRPC client receive size warning threshold must be between 0 and 1, got %v", cfg.RecvSizeWarnThreshold)
+	}
+	switch cfg.AddressFamily {
+	case "", addressFamilyIPv4, addressFamilyIPv6:
+	default:
+		return fmt.Errorf("unsupported address family: %s", cfg.AddressFamily)
+	}
+	if cfg.ServiceConfigJSON != "" {
+		if err := validateServiceConfig(cfg.ServiceConfigJSON); err != nil {
+			return err
+		}
+	}
+	if err := cfg.BackoffConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.CircuitBreaker.Validate(); err != nil {
+		return err
+	}
+	if cfg.DefaultCallTimeout < 0 || cfg.MaxCallTimeout < 0 {
+		return fmt.Errorf("gRPC client call timeouts can't be negative, got default %s and max %s", cfg.DefaultCallTimeout, cfg.MaxCallTimeout)
+	}
+	switch cfg.CredentialsType {
+	case "", CredentialsTypeTLS:
+	case CredentialsTypeInsecure, CredentialsTypeALTS:
+		if cfg.TLSEnabled {
+			return fmt.Errorf("TLS can't be enabled with the %s credentials type", cfg.CredentialsType)
+		}
 	default:
-		return errors.Errorf("unsupported compression type: %s", cfg.GRPCCompression)
+		return fmt.Errorf("unsupported credentials type: %s", cfg.CredentialsType)
+	}
+	if cfg.CredentialsBundle != nil {
+		if cfg.CredentialsType != "" || cfg.TLSEnabled {
+			return errors.New("the credentials bundle can't be used with a credentials type or TLS enabled, since it provides the transport credentials")
+		}
+		if cfg.PerRPCCredentials != nil {
+			return errors.New("the credentials bundle can't be used with per-RPC credentials, since it provides them")
+		}
+	}
+	if cfg.PerRPCCredentials != nil && cfg.PerRPCCredentials.RequireTransportSecurity() && cfg.credentialsType() == CredentialsTypeInsecure {
+		return errors.New("the per-RPC credentials require transport security, but the connections are insecure: set the credentials type to tls or alts, or enable TLS")
+	}
+	tlsCfg := cfg.tlsConfig()
+	if err := tlsCfg.Validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateCompression checks that the configured compressions are supported, and that
+// they are compatible with the other compression options.
+func (cfg *Config) validateCompression() error {
+	codec, err := resolveCompression(cfg.GRPCCompression)
+	if err != nil {
+		return err
+	}
+	for method, compression := range cfg.PerMethodCompression {
+		if _, err := resolveCompression(compression); err != nil {
+			return errors.Wrapf(err, "invalid compression for method %s", method)
+		}
+	}
+	for _, compression := range cfg.AcceptCompression {
+		if compression == "" {
+			return fmt.Errorf("%w: empty accepted compression", ErrUnsupportedCompression)
+		}
+		if _, err := resolveCompression(compression); err != nil {
+			return errors.Wrap(err, "invalid accepted compression")
+		}
+	}
+
+	if cfg.AdaptiveCompression && codec != nil && codec.checksum {
+		return fmt.Errorf("adaptive compression can't be used with the %s compression, since it may switch to a compression without checksum verification", codec.name)
+	}
+	if cfg.ServerPreferredCompression && codec != nil && codec.checksum {
+		return fmt.Errorf("server preferred compression can't be used with the %s compression, since it may switch to a compression without checksum verification", codec.name)
+	}
+	if cfg.CompressionNegotiation && codec != nil && codec.checksum {
+		return fmt.Errorf("compression negotiation can't be used with the %s compression, since it may fall back to calls without checksum verification", codec.name)
+	}
+	if cfg.CompressionDeadlineSkipBelow > 0 && !cfg.compressionEnabled() {
+		return errors.New("compression deadline skip can't be set with compression disabled")
+	}
+	if cfg.MinCompressSize < 0 {
+		return fmt.Errorf("gRPC client min compress size can't be negative, got %d", cfg.MinCompressSize)
+	}
+	if cfg.MinCompressSize > 0 && !cfg.compressionEnabled() {
+		return errors.New("min compress size can't be set with compression disabled")
+	}
+	if cfg.compressionEnabled() && cfg.MaxSendMsgSize > 0 && cfg.MaxSendMsgSize < minMaxSendMsgSizeWithCompression {
+		return fmt.Errorf("gRPC client max send message size %d is too small with compression enabled, since compression can make small messages larger: it must be at least %d bytes", cfg.MaxSendMsgSize, minMaxSendMsgSizeWithCompression)
 	}
 	return nil
 }
 
+// minMaxSendMsgSizeWithCompression is the smallest MaxSendMsgSize accepted when compression
+// is enabled: below it, the expansion of small incompressible messages (see
+// MaxCompressedSize) is a significant share of the limit.
+const minMaxSendMsgSizeWithCompression = 1024
+
+// compressionEnabled returns whether any call may be compressed.
+func (cfg *Config) compressionEnabled() bool {
+	if cfg.GRPCCompression != "" || cfg.AdaptiveCompression || cfg.ServerPreferredCompression || cfg.CompressorSelector != nil {
+		return true
+	}
+	for _, compression := range cfg.PerMethodCompression {
+		if compression != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrUnsupportedCompression is returned by Config.Validate when a configured compression
+// isn't supported. The returned error wraps it, so it can be detected with errors.Is.
+var ErrUnsupportedCompression = errors.New("unsupported compression type")
+
+// compressionCodec is a compression supported by the client.
+type compressionCodec struct {
+	name string
+	// checksum is whether the codec verifies the decompressed data with a checksum.
+	checksum bool
+}
+
+// resolveCompression returns the codec of the named compression, which must be gzip or
+// one of the dskit-provided compressors, and registered with gRPC. It returns nil for
+// the empty name, which means no compression.
+func resolveCompression(name string) (*compressionCodec, error) {
+	if name == "" {
+		return nil, nil
+	}
+	if name != gzip.Name && !isDskitCompressor(name) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCompression, name)
+	}
+	if encoding.GetCompressor(name) == nil {
+		return nil, fmt.Errorf("%w: %s is not registered", ErrUnsupportedCompression, name)
+	}
+	return &compressionCodec{name: name, checksum: name == checksum.SnappyName}, nil
+}
+
+func isDskitCompressor(compression string) bool {
+	for _, name := range grpcencoding.Names() {
+		if name == compression {
+			return true
+		}
+	}
+	return false
+}
+
 // CallOptions returns the config in terms of CallOptions.
 func (cfg *Config) CallOptions() []grpc.CallOption {
 	var opts []grpc.CallOption
@@ -69,35 +464,456 @@ func (cfg *Config) CallOptions() []grpc.CallOption {
 	if cfg.GRPCCompression != "" {
 		opts = append(opts, grpc.UseCompressor(cfg.GRPCCompression))
 	}
+	if cfg.DefaultWaitForReady {
+		opts = append(opts, grpc.WaitForReady(true))
+	}
 	return opts
 }
 
-// DialOption returns the config as a grpc.DialOptions.
+// DescribeCallOptions returns a human-readable summary of the CallOptions produced
+// by the config, useful when troubleshooting message size or compression errors.
+func (cfg *Config) DescribeCallOptions() string {
+	compression := cfg.GRPCCompression
+	if compression == "" {
+		compression = "none"
+	}
+	desc := fmt.Sprintf("max_recv_msg_size=%d max_send_msg_size=%d compression=%s", cfg.MaxRecvMsgSize, cfg.MaxSendMsgSize, compression)
+	if cfg.DefaultWaitForReady {
+		desc += " wait_for_ready=true"
+	}
+	return desc
+}
+
+// compressionConfigured returns whether the config picks a compressor for some calls,
+// which is when the compressor of a call is worth recording.
+func (cfg *Config) compressionConfigured() bool {
+	return cfg.GRPCCompression != "" || cfg.AdaptiveCompression || cfg.ServerPreferredCompression || len(cfg.PerMethodCompression) > 0 || cfg.CompressorSelector != nil
+}
+
+// DialOption returns the config as a grpc.DialOptions. Interceptors are executed in
+// order: the call timeout first, then the circuit breaker, the rate limiter, the backoff
+// retries, the compression interceptors (after which CompressorFromContext returns the
+// compressor of the call), the unary interceptors selected by name in Interceptors, and
+// finally the given unaryClientInterceptors and streamClientInterceptors, unless
+// UserInterceptorsFirst places them first.
 func (cfg *Config) DialOption(unaryClientInterceptors []grpc.UnaryClientInterceptor, streamClientInterceptors []grpc.StreamClientInterceptor) ([]grpc.DialOption, error) {
 	var opts []grpc.DialOption
-	tlsOpts, err := cfg.TLS.GetGRPCDialOptions(cfg.TLSEnabled)
+	if cfg.CredentialsBundle != nil {
+		opts = append(opts, grpc.WithCredentialsBundle(cfg.CredentialsBundle))
+	} else if cfg.credentialsType() == CredentialsTypeInsecure {
+		// gRPC only honors WithAuthority with WithInsecure, not with the insecure
+		// transport credentials.
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		creds, err := cfg.transportCredentials()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(creds))
+	}
+
+	if cfg.ResolverBuilder != nil {
+		opts = append(opts, grpc.WithResolvers(cfg.ResolverBuilder))
+	}
+
+	if cfg.Authority != "" {
+		opts = append(opts, grpc.WithAuthority(cfg.Authority))
+	}
+
+	shared := cfg.sharedInterceptors()
+	unary, _, err := cfg.unaryInterceptors(shared, unaryClientInterceptors)
 	if err != nil {
 		return nil, err
 	}
-	opts = append(opts, tlsOpts...)
+	stream, _ := cfg.streamInterceptors(shared, streamClientInterceptors)
 
-	if cfg.BackoffOnRatelimits {
-		unaryClientInterceptors = append([]grpc.UnaryClientInterceptor{NewBackoffRetry(cfg.BackoffConfig)}, unaryClientInterceptors...)
+	if cfg.DisableProxy {
+		opts = append(opts, grpc.WithNoProxy())
+	}
+
+	if dial := cfg.contextDialer(); dial != nil {
+		opts = append(opts, grpc.WithContextDialer(dial))
 	}
 
-	if cfg.RateLimit > 0 {
-		unaryClientInterceptors = append([]grpc.UnaryClientInterceptor{NewRateLimiter(cfg)}, unaryClientInterceptors...)
+	if cfg.ReturnConnectionError {
+		opts = append(opts, grpc.WithReturnConnectionError())
+	}
+
+	if cfg.BackoffOnReconnect {
+		// ConnectParams has no default for the minimum connect timeout, keep the gRPC one.
+		opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{
+			Backoff:           cfg.BackoffConfig.ToGRPCBackoffConfig(),
+			MinConnectTimeout: 20 * time.Second,
+		}))
+	}
+
+	if cfg.DisableHealthCheck {
+		opts = append(opts, grpc.WithDisableHealthCheck())
+	}
+
+	if cfg.PerRPCCredentials != nil {
+		opts = append(opts, grpc.WithPerRPCCredentials(cfg.PerRPCCredentials))
+	}
+
+	if cfg.ServiceConfigJSON != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(cfg.ServiceConfigJSON))
+	}
+
+	// A connection has a single stats handler, combining all the configured ones.
+	if statsHandlers, _ := cfg.statsHandlers(true); len(statsHandlers) > 0 {
+		opts = append(opts, grpc.WithStatsHandler(statsHandlers))
 	}
 
 	return append(
 		opts,
 		grpc.WithDefaultCallOptions(cfg.CallOptions()...),
-		grpc.WithUnaryInterceptor(middleware.ChainUnaryClient(unaryClientInterceptors...)),
-		grpc.WithStreamInterceptor(middleware.ChainStreamClient(streamClientInterceptors...)),
-		grpc.WithKeepaliveParams(keepalive.ClientParameters{
-			Time:                time.Second * 20,
-			Timeout:             time.Second * 10,
-			PermitWithoutStream: true,
-		}),
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+		grpc.WithKeepaliveParams(cfg.jitteredKeepaliveParams()),
 	), nil
 }
+
+// DescribeDialOptions returns a human-readable summary of each dial option applied by
+// DialOption, suitable for logging at startup. Interceptors and stats handlers are
+// listed by name, in the order they're executed; the interceptors passed to DialOption
+// by the caller are not included.
+func (cfg *Config) DescribeDialOptions() ([]string, error) {
+	_, unaryNames, err := cfg.unaryInterceptors(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, streamNames := cfg.streamInterceptors(nil, nil)
+
+	creds := cfg.credentialsType()
+	if cfg.CredentialsBundle != nil {
+		creds = "bundle"
+	}
+	desc := []string{"credentials: " + creds}
+	if cfg.ResolverBuilder != nil {
+		desc = append(desc, "resolver: "+cfg.ResolverBuilder.Scheme())
+	}
+	if cfg.Authority != "" {
+		desc = append(desc, "authority: "+cfg.Authority)
+	}
+	if cfg.DisableProxy {
+		desc = append(desc, "proxy: disabled")
+	}
+	if cfg.AddressFamily != "" {
+		desc = append(desc, "address family: "+cfg.AddressFamily)
+	}
+	if cfg.DisableNagle {
+		desc = append(desc, "nagle: disabled")
+	}
+	if cfg.ReturnConnectionError {
+		desc = append(desc, "return connection error: enabled")
+	}
+	if cfg.BackoffOnReconnect {
+		desc = append(desc, "reconnection backoff: enabled")
+	}
+	if cfg.DisableHealthCheck {
+		desc = append(desc, "health check: disabled")
+	}
+	if cfg.PerRPCCredentials != nil {
+		desc = append(desc, "per-RPC credentials: enabled")
+	}
+	if cfg.ServiceConfigJSON != "" {
+		desc = append(desc, "service config: "+cfg.ServiceConfigJSON)
+	}
+	if _, names := cfg.statsHandlers(false); len(names) > 0 {
+		desc = append(desc, "stats handlers: "+strings.Join(names, ", "))
+	}
+
+	params := cfg.keepaliveParams()
+	return append(desc,
+		"call options: "+cfg.DescribeCallOptions(),
+		"unary interceptors: "+strings.Join(unaryNames, ", "),
+		"stream interceptors: "+strings.Join(streamNames, ", "),
+		fmt.Sprintf("keepalive: time=%s timeout=%s jitter=%s permit_without_stream=%t", params.Time, params.Timeout, cfg.KeepaliveJitter, params.PermitWithoutStream),
+	), nil
+}
+
+// sharedInterceptors holds the unary and stream interceptors sharing their state.
+type sharedInterceptors struct {
+	compressionNegotiationUnary  grpc.UnaryClientInterceptor
+	compressionNegotiationStream grpc.StreamClientInterceptor
+
+	// The circuit breaker resets the shared backoff when becoming half-open.
+	circuitBreaker grpc.UnaryClientInterceptor
+	sharedBackoff  *sharedBackoff
+}
+
+func (cfg *Config) sharedInterceptors() *sharedInterceptors {
+	var shared sharedInterceptors
+	if cfg.BackoffOnRatelimits && cfg.BackoffShared {
+		shared.sharedBackoff = newSharedBackoff(cfg.BackoffConfig)
+	}
+	if cfg.CircuitBreaker.Enabled {
+		var onHalfOpen func()
+		if shared.sharedBackoff != nil {
+			onHalfOpen = shared.sharedBackoff.reset
+		}
+		shared.circuitBreaker = newCircuitBreaker(cfg.CircuitBreaker, onHalfOpen).unaryClientInterceptor()
+	}
+	if cfg.CompressionNegotiation {
+		shared.compressionNegotiationUnary, shared.compressionNegotiationStream = NewCompressionNegotiation(cfg.logger())
+	}
+	return &shared
+}
+
+// ActiveInterceptorNames returns the names of the interceptors DialOption installs given
+// the config, e.g. "rate_limiter" or "backoff_retry", for troubleshooting: the names of
+// the unary chain in the order they're executed, followed by the names of the stream
+// chain not already listed. The interceptors passed to DialOption by the caller, e.g.
+// the tracing ones returned by Instrument, are not included. It returns nil if the
+// config is invalid, see DescribeDialOptions for the error.
+func (cfg *Config) ActiveInterceptorNames() []string {
+	_, names, err := cfg.unaryInterceptors(nil, nil)
+	if err != nil {
+		return nil
+	}
+	_, streamNames := cfg.streamInterceptors(nil, nil)
+
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+	}
+	for _, name := range streamNames {
+		if !listed[name] {
+			listed[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// unaryInterceptors returns the unary interceptor chain built from the config followed
+// by the given callerInterceptors, along with the name of each interceptor. If shared is
+// nil, only the names are returned: the interceptors aren't created, so that the chain
+// can be described without side effects, e.g. registering metrics.
+func (cfg *Config) unaryInterceptors(shared *sharedInterceptors, callerInterceptors []grpc.UnaryClientInterceptor) ([]grpc.UnaryClientInterceptor, []string, error) {
+	// Always build new slices, so that the resulting chains never share memory with
+	// the caller-owned ones (which the caller may modify or pass to another call).
+	var (
+		unary []grpc.UnaryClientInterceptor
+		names []string
+	)
+	add := func(name string, build func() grpc.UnaryClientInterceptor) {
+		names = append(names, name)
+		if shared != nil {
+			unary = append(unary, build())
+		}
+	}
+	addCallerInterceptors := func() {
+		for _, interceptor := range callerInterceptors {
+			interceptor := interceptor
+			add("custom", func() grpc.UnaryClientInterceptor { return interceptor })
+		}
+	}
+
+	if cfg.UserInterceptorsFirst {
+		addCallerInterceptors()
+	}
+	// The timeouts bound the whole call, including the rate limiting and backoff waits.
+	if cfg.DefaultCallTimeout > 0 || cfg.MaxCallTimeout > 0 {
+		add("call_timeout", func() grpc.UnaryClientInterceptor {
+			return NewCallTimeout(cfg.DefaultCallTimeout, cfg.MaxCallTimeout)
+		})
+	}
+	if cfg.CircuitBreaker.Enabled {
+		add("circuit_breaker", func() grpc.UnaryClientInterceptor { return shared.circuitBreaker })
+	}
+	if !cfg.RateLimitDisabled {
+		switch {
+		case cfg.PerTenantRateLimit > 0:
+			add("per_tenant_rate_limiter", func() grpc.UnaryClientInterceptor { return NewPerTenantRateLimiter(cfg) })
+		case cfg.RateLimiter != nil:
+			add("rate_limiter", cfg.RateLimiter.UnaryClientInterceptor)
+		case cfg.RateLimit > 0:
+			add("rate_limiter", func() grpc.UnaryClientInterceptor { return NewRateLimiter(cfg) })
+		}
+	}
+	if cfg.BackoffOnRatelimits {
+		if cfg.BackoffShared {
+			add("shared_backoff_retry", func() grpc.UnaryClientInterceptor {
+				return newBackoffRetry(cfg.BackoffConfig, shared.sharedBackoff, newBackoffRetryMetrics(cfg.Registerer))
+			})
+		} else {
+			add("backoff_retry", func() grpc.UnaryClientInterceptor {
+				return NewBackoffRetryWithRegisterer(cfg.BackoffConfig, cfg.Registerer)
+			})
+		}
+		if cfg.BackoffRetryCountMetadata {
+			add("retry_count", NewRetryCount)
+		}
+	}
+	if cfg.BackoffOnUnavailable {
+		add("reconnect_retry", func() grpc.UnaryClientInterceptor {
+			return NewReconnectRetryWithRegisterer(cfg.BackoffConfig, cfg.Registerer)
+		})
+	}
+	if cfg.AdaptiveCompression {
+		add("adaptive_compression", NewAdaptiveCompression)
+	}
+	if cfg.ServerPreferredCompression {
+		add("server_preferred_compression", NewPreferredCompression)
+	}
+	if len(cfg.PerMethodCompression) > 0 {
+		add("per_method_compression", func() grpc.UnaryClientInterceptor {
+			return NewUnaryPerMethodCompression(cfg.PerMethodCompression)
+		})
+	}
+	if cfg.CompressorSelector != nil {
+		add("compressor_selection", func() grpc.UnaryClientInterceptor {
+			return NewUnaryCompressorSelection(cfg.CompressorSelector)
+		})
+	}
+	if cfg.compressionConfigured() {
+		add("compression_disabler", NewUnaryCompressionDisabler)
+	}
+	if cfg.CompressionDeadlineSkipBelow > 0 {
+		add("compression_deadline_skip", func() grpc.UnaryClientInterceptor {
+			return NewCompressionDeadlineSkip(cfg.CompressionDeadlineSkipBelow)
+		})
+	}
+	if cfg.MinCompressSize > 0 {
+		add("min_compress_size", func() grpc.UnaryClientInterceptor { return NewMinCompressSize(cfg.MinCompressSize) })
+	}
+	if cfg.CompressionNegotiation {
+		add("compression_negotiation", func() grpc.UnaryClientInterceptor { return shared.compressionNegotiationUnary })
+	}
+	if len(cfg.AcceptCompression) > 0 {
+		add("accept_compression", func() grpc.UnaryClientInterceptor {
+			acceptCompressionUnary, _ := NewAcceptCompression(cfg.AcceptCompression...)
+			return acceptCompressionUnary
+		})
+	}
+	if cfg.compressionConfigured() {
+		add("compressor_recorder", NewUnaryCompressorRecorder)
+	}
+	for _, name := range cfg.Interceptors {
+		ctor, err := registeredUnaryInterceptor(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		add(name, func() grpc.UnaryClientInterceptor { return ctor(*cfg) })
+	}
+	if !cfg.UserInterceptorsFirst {
+		addCallerInterceptors()
+	}
+	// Required metadata is checked last, as it may be set by the caller interceptors.
+	if len(cfg.RequiredMetadataKeys) > 0 {
+		add("require_metadata", func() grpc.UnaryClientInterceptor {
+			requireMetadataUnary, _ := NewRequireMetadata(cfg.RequiredMetadataKeys...)
+			return requireMetadataUnary
+		})
+	}
+	return unary, names, nil
+}
+
+// streamInterceptors returns the stream interceptor chain built from the config followed
+// by the given callerInterceptors, along with the name of each interceptor. If shared is
+// nil, only the names are returned, see unaryInterceptors.
+func (cfg *Config) streamInterceptors(shared *sharedInterceptors, callerInterceptors []grpc.StreamClientInterceptor) ([]grpc.StreamClientInterceptor, []string) {
+	var (
+		stream []grpc.StreamClientInterceptor
+		names  []string
+	)
+	add := func(name string, build func() grpc.StreamClientInterceptor) {
+		names = append(names, name)
+		if shared != nil {
+			stream = append(stream, build())
+		}
+	}
+	addCallerInterceptors := func() {
+		for _, interceptor := range callerInterceptors {
+			interceptor := interceptor
+			add("custom", func() grpc.StreamClientInterceptor { return interceptor })
+		}
+	}
+
+	if cfg.UserInterceptorsFirst {
+		addCallerInterceptors()
+	}
+	if cfg.Registerer != nil {
+		add("latency_instrumentation", func() grpc.StreamClientInterceptor {
+			return NewStreamLatencyInstrumentation(cfg.Registerer)
+		})
+	}
+	if cfg.MaxStreamLifetime > 0 {
+		add("max_lifetime", func() grpc.StreamClientInterceptor { return NewStreamMaxLifetime(cfg.MaxStreamLifetime) })
+	}
+	if len(cfg.PerMethodCompression) > 0 {
+		add("per_method_compression", func() grpc.StreamClientInterceptor {
+			return NewStreamPerMethodCompression(cfg.PerMethodCompression)
+		})
+	}
+	if cfg.CompressorSelector != nil {
+		add("compressor_selection", func() grpc.StreamClientInterceptor {
+			return NewStreamCompressorSelection(cfg.CompressorSelector)
+		})
+	}
+	if cfg.GRPCCompression != "" || len(cfg.PerMethodCompression) > 0 || cfg.CompressorSelector != nil {
+		add("compression_disabler", NewStreamCompressionDisabler)
+	}
+	if cfg.CompressionNegotiation {
+		add("compression_negotiation", func() grpc.StreamClientInterceptor { return shared.compressionNegotiationStream })
+	}
+	if len(cfg.AcceptCompression) > 0 {
+		add("accept_compression", func() grpc.StreamClientInterceptor {
+			_, acceptCompressionStream := NewAcceptCompression(cfg.AcceptCompression...)
+			return acceptCompressionStream
+		})
+	}
+	if cfg.compressionConfigured() {
+		add("compressor_recorder", NewStreamCompressorRecorder)
+	}
+	if !cfg.UserInterceptorsFirst {
+		addCallerInterceptors()
+	}
+	if len(cfg.RequiredMetadataKeys) > 0 {
+		add("require_metadata", func() grpc.StreamClientInterceptor {
+			_, requireMetadataStream := NewRequireMetadata(cfg.RequiredMetadataKeys...)
+			return requireMetadataStream
+		})
+	}
+	return stream, names
+}
+
+// statsHandlers returns the stats handlers built from the config, along with the name
+// of each handler. If build is false, only the names are returned, without creating the
+// handlers.
+func (cfg *Config) statsHandlers(build bool) (multiStatsHandler, []string) {
+	var (
+		handlers multiStatsHandler
+		names    []string
+	)
+	add := func(name string, newHandler func() stats.Handler) {
+		names = append(names, name)
+		if build {
+			handlers = append(handlers, newHandler())
+		}
+	}
+
+	if cfg.ChannelLabel != "" {
+		add("channel_label", func() stats.Handler { return &channelLabelStatsHandler{label: cfg.ChannelLabel} })
+	}
+	if cfg.LogKeepalive {
+		add("keepalive_logging", func() stats.Handler { return newKeepaliveStatsHandler(cfg.logger()) })
+	}
+	if cfg.InstrumentSizes && cfg.Registerer != nil {
+		add("size_instrumentation", func() stats.Handler { return newSizeStatsHandler(cfg.Registerer) })
+	}
+	if cfg.RecvSizeWarnThreshold > 0 {
+		add("recv_size_warning", func() stats.Handler {
+			return newRecvSizeWarningStatsHandler(cfg.logger(), cfg.RecvSizeWarnThreshold, cfg.MaxRecvMsgSize)
+		})
+	}
+	return handlers, names
+}
+
+func (cfg *Config) logger() log.Logger {
+	if cfg.Logger == nil {
+		return log.NewNopLogger()
+	}
+	return cfg.Logger
+}
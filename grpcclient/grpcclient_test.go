@@ -0,0 +1,746 @@
+package grpcclient_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/grpcclient"
+	"github.com/grafana/dskit/grpcencoding/checksum"
+)
+
+func TestDialOptionWithAuthority(t *testing.T) {
+	authorities := make(chan []string, 1)
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		authorities <- md[":authority"]
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 1 << 20,
+		MaxSendMsgSize: 1 << 20,
+		Authority:      "virtual-host.example.com",
+	}
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	conn, err := grpc.Dial("bufconn", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"virtual-host.example.com"}, <-authorities)
+}
+
+func TestDialOptionWithDisableProxy(t *testing.T) {
+	// The proxy environment variables are only read once per process, so the test runs
+	// in a child process with HTTPS_PROXY pointing to an address nothing listens on.
+	if os.Getenv("GRPCCLIENT_TEST_DISABLE_PROXY") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDialOptionWithDisableProxy$")
+		cmd.Env = append(os.Environ(), "GRPCCLIENT_TEST_DISABLE_PROXY=1", "HTTPS_PROXY=127.0.0.1:1", "NO_PROXY=")
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return
+	}
+
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	// Loopback addresses are never proxied, but 0.0.0.0 reaches the listener as well.
+	addr := fmt.Sprintf("0.0.0.0:%d", listener.Addr().(*net.TCPAddr).Port)
+
+	for _, disableProxy := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disable proxy=%t", disableProxy), func(t *testing.T) {
+			cfg := grpcclient.Config{
+				MaxRecvMsgSize: 1 << 20,
+				MaxSendMsgSize: 1 << 20,
+				DisableProxy:   disableProxy,
+			}
+			opts, err := cfg.DialOption(nil, nil)
+			require.NoError(t, err)
+			conn, err := grpc.Dial(addr, opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true))
+			if disableProxy {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err, "the call should go through the unreachable proxy")
+			}
+		})
+	}
+}
+
+func TestDialOptionWithDisableHealthCheck(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	for _, disableHealthCheck := range []bool{false, true} {
+		t.Run(fmt.Sprintf("disable health check=%t", disableHealthCheck), func(t *testing.T) {
+			cfg := grpcclient.Config{
+				MaxRecvMsgSize:     1 << 20,
+				MaxSendMsgSize:     1 << 20,
+				ServiceConfigJSON:  `{"loadBalancingConfig": [{"round_robin": {}}], "healthCheckConfig": {"serviceName": ""}}`,
+				DisableHealthCheck: disableHealthCheck,
+			}
+			require.NoError(t, cfg.Validate(nil))
+			opts, err := cfg.DialOption(nil, nil)
+			require.NoError(t, err)
+			opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}))
+			conn, err := grpc.Dial("bufconn", opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// The server reports itself as not serving, which only matters to the health checks.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			resp, err := grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{}, grpc.WaitForReady(true))
+			if disableHealthCheck {
+				require.NoError(t, err)
+				assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, resp.Status)
+			} else {
+				require.Error(t, err, "the health checks should keep the backend out of the load balancer")
+			}
+		})
+	}
+}
+
+func TestDialOptionDoesNotMutateSharedInterceptorSlices(t *testing.T) {
+	cfg := grpcclient.Config{
+		RateLimit:           1,
+		BackoffOnRatelimits: true,
+	}
+
+	// Leave spare capacity so that any in-place append would be visible.
+	unary := make([]grpc.UnaryClientInterceptor, 1, 10)
+	unary[0] = func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	stream := make([]grpc.StreamClientInterceptor, 0, 10)
+
+	first, err := cfg.DialOption(unary, stream)
+	require.NoError(t, err)
+	second, err := cfg.DialOption(unary, stream)
+	require.NoError(t, err)
+
+	assert.Len(t, second, len(first))
+	assert.Len(t, unary, 1)
+	assert.Len(t, stream, 0)
+	for _, i := range unary[1:cap(unary)] {
+		assert.Nil(t, i)
+	}
+}
+
+func TestDialOptionIsNotAffectedByLaterChangesToInterceptorSlices(t *testing.T) {
+	var called []string
+	recorder := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			called = append(called, name)
+			return nil
+		}
+	}
+
+	cfg := grpcclient.Config{}
+	unary := []grpc.UnaryClientInterceptor{recorder("first")}
+
+	firstOpts, err := cfg.DialOption(unary, nil)
+	require.NoError(t, err)
+
+	// Reuse the same slice for a second call, overwriting its content.
+	unary[0] = recorder("second")
+	secondOpts, err := cfg.DialOption(unary, nil)
+	require.NoError(t, err)
+
+	for _, opts := range [][]grpc.DialOption{firstOpts, secondOpts} {
+		conn, err := grpc.Dial("localhost:0", opts...)
+		require.NoError(t, err)
+		require.NoError(t, conn.Invoke(context.Background(), "/test/method", nil, nil))
+		require.NoError(t, conn.Close())
+	}
+
+	assert.Equal(t, []string{"first", "second"}, called)
+}
+
+func TestDescribeCallOptions(t *testing.T) {
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 100 << 20,
+		MaxSendMsgSize: 16 << 20,
+	}
+	assert.Equal(t, "max_recv_msg_size=104857600 max_send_msg_size=16777216 compression=none", cfg.DescribeCallOptions())
+
+	cfg.GRPCCompression = "snappy"
+	assert.Equal(t, "max_recv_msg_size=104857600 max_send_msg_size=16777216 compression=snappy", cfg.DescribeCallOptions())
+
+	cfg.DefaultWaitForReady = true
+	assert.Equal(t, "max_recv_msg_size=104857600 max_send_msg_size=16777216 compression=snappy wait_for_ready=true", cfg.DescribeCallOptions())
+}
+
+func TestDescribeCallOptionsMatchesCallOptions(t *testing.T) {
+	// describe describes the given call options the way DescribeCallOptions does.
+	describe := func(opts []grpc.CallOption) string {
+		var recvSize, sendSize int
+		compression, waitForReady := "none", ""
+		for _, opt := range opts {
+			switch o := opt.(type) {
+			case grpc.MaxRecvMsgSizeCallOption:
+				recvSize = o.MaxRecvMsgSize
+			case grpc.MaxSendMsgSizeCallOption:
+				sendSize = o.MaxSendMsgSize
+			case grpc.CompressorCallOption:
+				compression = o.CompressorType
+			case grpc.FailFastCallOption:
+				if !o.FailFast {
+					waitForReady = " wait_for_ready=true"
+				}
+			default:
+				t.Fatalf("call option %T isn't described", opt)
+			}
+		}
+		return fmt.Sprintf("max_recv_msg_size=%d max_send_msg_size=%d compression=%s%s", recvSize, sendSize, compression, waitForReady)
+	}
+
+	for name, cfg := range map[string]grpcclient.Config{
+		"sizes only":     {MaxRecvMsgSize: 100 << 20, MaxSendMsgSize: 16 << 20},
+		"compression":    {MaxRecvMsgSize: 1024, MaxSendMsgSize: 1024, GRPCCompression: "gzip"},
+		"wait for ready": {MaxRecvMsgSize: 1024, MaxSendMsgSize: 1024, DefaultWaitForReady: true},
+		"all":            {MaxRecvMsgSize: 1024, MaxSendMsgSize: 1024, GRPCCompression: "snappy", DefaultWaitForReady: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, describe(cfg.CallOptions()), cfg.DescribeCallOptions())
+		})
+	}
+}
+
+func TestDescribeDialOptions(t *testing.T) {
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:      100 << 20,
+		MaxSendMsgSize:      16 << 20,
+		GRPCCompression:     "snappy",
+		RateLimit:           10,
+		RateLimitBurst:      5,
+		BackoffOnRatelimits: true,
+		BackoffConfig:       backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: time.Second, MaxRetries: 3},
+		BackoffOnReconnect:  true,
+		MaxStreamLifetime:   time.Minute,
+		Authority:           "example.com",
+		ChannelLabel:        "ingester",
+		KeepaliveTime:       30 * time.Second,
+		KeepaliveTimeout:    5 * time.Second,
+	}
+
+	desc, err := cfg.DescribeDialOptions()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"credentials: insecure",
+		"authority: example.com",
+		"reconnection backoff: enabled",
+		"stats handlers: channel_label",
+		"call options: max_recv_msg_size=104857600 max_send_msg_size=16777216 compression=snappy",
+		"unary interceptors: rate_limiter, backoff_retry, compression_disabler, compressor_recorder",
+		"stream interceptors: max_lifetime, compression_disabler, compressor_recorder",
+		"keepalive: time=30s timeout=5s jitter=0s permit_without_stream=true",
+	}, desc)
+
+	t.Run("unknown interceptor", func(t *testing.T) {
+		cfg := cfg
+		cfg.Interceptors = []string{"unknown"}
+		_, err := cfg.DescribeDialOptions()
+		require.Error(t, err)
+	})
+
+	t.Run("doesn't create the interceptors", func(t *testing.T) {
+		reg := prometheus.NewPedanticRegistry()
+		cfg := cfg
+		cfg.Registerer = reg
+		cfg.InstrumentSizes = true
+		cfg.BackoffShared = true
+		cfg.CircuitBreaker.Enabled = true
+
+		_, err := cfg.DescribeDialOptions()
+		require.NoError(t, err)
+		assert.NotEmpty(t, cfg.ActiveInterceptorNames())
+
+		metrics, err := reg.Gather()
+		require.NoError(t, err)
+		assert.Empty(t, metrics)
+	})
+}
+
+func TestActiveInterceptorNames(t *testing.T) {
+	for name, test := range map[string]struct {
+		cfg      grpcclient.Config
+		expected []string
+	}{
+		"defaults": {},
+		"rate limit and backoff": {
+			cfg: grpcclient.Config{
+				RateLimit:           10,
+				BackoffOnRatelimits: true,
+				BackoffConfig:       backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: time.Second, MaxRetries: 3},
+			},
+			expected: []string{"rate_limiter", "backoff_retry"},
+		},
+		"rate limit disabled": {
+			cfg:      grpcclient.Config{RateLimitDisabled: true, RateLimit: 10},
+			expected: nil,
+		},
+		"unary and stream features": {
+			cfg: grpcclient.Config{
+				DefaultCallTimeout:   time.Second,
+				GRPCCompression:      "snappy",
+				MaxStreamLifetime:    time.Minute,
+				RequiredMetadataKeys: []string{"x-request-id"},
+			},
+			expected: []string{"call_timeout", "compression_disabler", "compressor_recorder", "require_metadata", "max_lifetime"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.cfg.ActiveInterceptorNames())
+		})
+	}
+
+	t.Run("unknown interceptor", func(t *testing.T) {
+		cfg := grpcclient.Config{Interceptors: []string{"unknown"}}
+		assert.Nil(t, cfg.ActiveInterceptorNames())
+	})
+}
+
+func TestDialOptionWithResolverBuilder(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	builder := manual.NewBuilderWithScheme("dskit-test")
+	builder.InitialState(resolver.State{Addresses: []resolver.Address{{Addr: "resolved-backend"}}})
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:  1024,
+		MaxSendMsgSize:  1024,
+		ResolverBuilder: builder,
+	}
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+
+	var dialed []string
+	opts = append(opts, grpc.WithContextDialer(func(_ context.Context, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		return listener.Dial()
+	}))
+
+	conn, err := grpc.Dial("dskit-test:///service", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"resolved-backend"}, dialed)
+}
+
+func TestConfigValidateCompression(t *testing.T) {
+	for _, compression := range []string{"", "gzip", "snappy", "snappy-crc"} {
+		cfg := grpcclient.Config{GRPCCompression: compression}
+		assert.NoError(t, cfg.Validate(nil), compression)
+	}
+
+	cfg := grpcclient.Config{GRPCCompression: "lz4"}
+	err := cfg.Validate(nil)
+	assert.EqualError(t, err, "unsupported compression type: lz4")
+	assert.True(t, errors.Is(err, grpcclient.ErrUnsupportedCompression))
+
+	cfg = grpcclient.Config{PerMethodCompression: map[string]string{"/test/Query": "lz4"}}
+	assert.True(t, errors.Is(cfg.Validate(nil), grpcclient.ErrUnsupportedCompression))
+}
+
+func TestChecksumCompressionEndToEnd(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:  1024,
+		MaxSendMsgSize:  1024,
+		GRPCCompression: checksum.SnappyName,
+	}
+	require.NoError(t, cfg.Validate(nil))
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+
+	conn, err := grpc.Dial("bufconn", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+}
+
+func TestDialOptionUnaryInterceptorsOrder(t *testing.T) {
+	var called []string
+	recorder := func(name string, failFirstAttempt bool) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			attempt, _ := backoff.AttemptFromContext(ctx)
+			called = append(called, fmt.Sprintf("%s:%d", name, attempt))
+			if failFirstAttempt && attempt == 1 {
+				return status.Error(codes.ResourceExhausted, "rate limited")
+			}
+			if failFirstAttempt {
+				return nil
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 1024,
+		MaxSendMsgSize: 1024,
+		// The burst allows a single call: if the rate limiter ran inside the backoff
+		// retry, the retry would have to wait far beyond the context deadline.
+		RateLimit:           0.001,
+		RateLimitBurst:      1,
+		BackoffOnRatelimits: true,
+		BackoffConfig: backoff.Config{
+			MinBackoff: time.Millisecond,
+			MaxBackoff: time.Millisecond,
+			MaxRetries: 3,
+		},
+	}
+	opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{recorder("first", false), recorder("second", true)}, nil)
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial("localhost:0", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, conn.Invoke(ctx, "/test/method", nil, nil))
+
+	// The backoff retry wraps the caller interceptors, which run in the given order.
+	assert.Equal(t, []string{"first:1", "second:1", "first:2", "second:2"}, called)
+}
+
+func TestDialOptionWithUserInterceptorsFirst(t *testing.T) {
+	var called []string
+	recorder := func(name string, failFirstAttempt bool) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			attempt, _ := backoff.AttemptFromContext(ctx)
+			called = append(called, fmt.Sprintf("%s:%d", name, attempt))
+			if !failFirstAttempt {
+				return invoker(ctx, method, req, reply, cc, opts...)
+			}
+			// Without the backoff retry, the attempt isn't set.
+			if attempt <= 1 {
+				return status.Error(codes.ResourceExhausted, "rate limited")
+			}
+			return nil
+		}
+	}
+
+	for _, test := range []struct {
+		userInterceptorsFirst bool
+		expectedCode          codes.Code
+		expectedCalls         []string
+	}{
+		// The backoff retry wraps the caller interceptors, which see every attempt.
+		{userInterceptorsFirst: false, expectedCode: codes.OK, expectedCalls: []string{"first:1", "second:1", "first:2", "second:2"}},
+		// The caller interceptors wrap the backoff retry, so nothing retries their errors.
+		{userInterceptorsFirst: true, expectedCode: codes.ResourceExhausted, expectedCalls: []string{"first:0", "second:0"}},
+	} {
+		t.Run(fmt.Sprintf("user interceptors first=%t", test.userInterceptorsFirst), func(t *testing.T) {
+			called = nil
+			cfg := grpcclient.Config{
+				UserInterceptorsFirst: test.userInterceptorsFirst,
+				BackoffOnRatelimits:   true,
+				BackoffConfig: backoff.Config{
+					MinBackoff: time.Millisecond,
+					MaxBackoff: time.Millisecond,
+					MaxRetries: 3,
+				},
+			}
+			opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{recorder("first", false), recorder("second", true)}, nil)
+			require.NoError(t, err)
+
+			conn, err := grpc.Dial("localhost:0", opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			err = conn.Invoke(context.Background(), "/test/method", nil, nil)
+			assert.Equal(t, test.expectedCode, status.Code(err))
+			assert.Equal(t, test.expectedCalls, called)
+		})
+	}
+}
+
+func TestDialOptionWithRateLimitDisabled(t *testing.T) {
+	// The caller interceptor completes the calls without sending them.
+	noop := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return nil
+	}
+
+	for _, enabled := range []bool{true, false} {
+		t.Run(fmt.Sprintf("enabled=%t", enabled), func(t *testing.T) {
+			cfg := grpcclient.Config{
+				RateLimitDisabled: !enabled,
+				RateLimit:         0.001,
+				RateLimitBurst:    1,
+			}
+			desc, err := cfg.DescribeDialOptions()
+			require.NoError(t, err)
+			if enabled {
+				assert.Contains(t, desc, "unary interceptors: rate_limiter")
+			} else {
+				assert.Contains(t, desc, "unary interceptors: ")
+			}
+
+			opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{noop}, nil)
+			require.NoError(t, err)
+			conn, err := grpc.Dial("localhost:0", opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			// The burst allows a single call: the second one would wait far beyond the deadline.
+			var codesByCall []codes.Code
+			for i := 0; i < 2; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				codesByCall = append(codesByCall, status.Code(conn.Invoke(ctx, "/test/method", nil, nil)))
+				cancel()
+			}
+			if enabled {
+				assert.Equal(t, []codes.Code{codes.OK, codes.ResourceExhausted}, codesByCall)
+			} else {
+				assert.Equal(t, []codes.Code{codes.OK, codes.OK}, codesByCall)
+			}
+		})
+	}
+}
+
+func TestDialOptionStreamInterceptorsOrder(t *testing.T) {
+	var called []string
+	recorder := func(name string) grpc.StreamClientInterceptor {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			// The max stream lifetime interceptor runs before the caller ones.
+			_, hasDeadline := ctx.Deadline()
+			called = append(called, fmt.Sprintf("%s:%t", name, hasDeadline))
+			if name == "second" {
+				return nil, status.Error(codes.Unavailable, "not connected")
+			}
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+	}
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:    1024,
+		MaxSendMsgSize:    1024,
+		MaxStreamLifetime: time.Minute,
+	}
+	opts, err := cfg.DialOption(nil, []grpc.StreamClientInterceptor{recorder("first"), recorder("second")})
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial("localhost:0", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.NewStream(context.Background(), &grpc.StreamDesc{ServerStreams: true}, "/test/method")
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, []string{"first:true", "second:true"}, called)
+}
+
+func TestCallOptionsWithDefaultWaitForReady(t *testing.T) {
+	hasWaitForReady := func(opts []grpc.CallOption) bool {
+		for _, opt := range opts {
+			if o, ok := opt.(grpc.FailFastCallOption); ok && !o.FailFast {
+				return true
+			}
+		}
+		return false
+	}
+
+	cfg := grpcclient.Config{}
+	assert.False(t, hasWaitForReady(cfg.CallOptions()))
+
+	cfg.DefaultWaitForReady = true
+	assert.True(t, hasWaitForReady(cfg.CallOptions()))
+}
+
+func TestConfigValidateServiceConfigJSON(t *testing.T) {
+	for name, test := range map[string]struct {
+		serviceConfig string
+		expectedErr   string
+	}{
+		"empty":          {serviceConfig: ""},
+		"valid":          {serviceConfig: `{"loadBalancingConfig": [{"round_robin": {}}]}`},
+		"malformed JSON": {serviceConfig: `{"loadBalancingConfig": [`, expectedErr: "invalid service config JSON"},
+		"invalid field":  {serviceConfig: `{"methodConfig": [{"name": [{"service": "foo"}], "timeout": "1x"}]}`, expectedErr: `malformed duration "1x"`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := grpcclient.Config{ServiceConfigJSON: test.serviceConfig}
+			err := cfg.Validate(nil)
+			if test.expectedErr == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.expectedErr)
+			}
+		})
+	}
+}
+
+func TestDialOptionWithServiceConfigJSON(t *testing.T) {
+	cfg := grpcclient.Config{}
+	withoutServiceConfig, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+
+	cfg.ServiceConfigJSON = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+	withServiceConfig, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+
+	assert.Len(t, withServiceConfig, len(withoutServiceConfig)+1)
+}
+
+func TestDialOptionWithInstrumentSizes(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("abcdef", grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(server, healthServer)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	reg := prometheus.NewPedanticRegistry()
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:  1024,
+		MaxSendMsgSize:  1024,
+		InstrumentSizes: true,
+		Registerer:      reg,
+		// Composes with the other stats handlers.
+		ChannelLabel: "test",
+		LogKeepalive: true,
+	}
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+
+	conn, err := grpc.Dial("bufconn", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The request is 8 bytes and the response 2, plus the 5 bytes gRPC message header.
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "abcdef"})
+	require.NoError(t, err)
+
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+	sums := map[string]float64{}
+	for _, family := range metrics {
+		for _, m := range family.GetMetric() {
+			if m.GetHistogram() != nil {
+				assert.Equal(t, "/grpc.health.v1.Health/Check", m.GetLabel()[0].GetValue())
+				assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+				sums[family.GetName()] = m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	assert.Equal(t, 13.0, sums["grpc_client_request_size_bytes"])
+	assert.Equal(t, 7.0, sums["grpc_client_response_size_bytes"])
+}
+
+func TestDialOptionWithReturnConnectionError(t *testing.T) {
+	// A plaintext server, which a client configured with TLS can't handshake with.
+	listener, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	server := grpc.NewServer()
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	for name, test := range map[string]struct {
+		returnConnectionError bool
+		expectedErr           string
+	}{
+		"without connection error": {returnConnectionError: false, expectedErr: "context deadline exceeded"},
+		"with connection error":    {returnConnectionError: true, expectedErr: "authentication handshake failed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := grpcclient.Config{CredentialsType: grpcclient.CredentialsTypeTLS, ReturnConnectionError: test.returnConnectionError}
+			opts, err := cfg.DialOption(nil, nil)
+			require.NoError(t, err)
+			if !test.returnConnectionError {
+				opts = append(opts, grpc.WithBlock())
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			conn, err := grpc.DialContext(ctx, listener.Addr().String(), opts...)
+			if conn != nil {
+				_ = conn.Close()
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.expectedErr)
+			if !test.returnConnectionError {
+				assert.NotContains(t, err.Error(), "handshake")
+			}
+		})
+	}
+}
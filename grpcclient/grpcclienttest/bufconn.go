@@ -0,0 +1,48 @@
+// Package grpcclienttest provides helpers to test the gRPC clients configured with
+// grpcclient.Config.
+package grpcclienttest
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+const bufconnSize = 1024 * 1024
+
+// NewBufconnClient starts an in-memory gRPC server, on which registerServer registers
+// the services under test, and returns a connection to it configured with cfg. The
+// server and the connection are stopped at the end of the test.
+func NewBufconnClient(t testing.TB, cfg grpcclient.Config, registerServer func(*grpc.Server)) *grpc.ClientConn {
+	t.Helper()
+
+	listener := bufconn.Listen(bufconnSize)
+	server := grpc.NewServer()
+	registerServer(server)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	opts, err := cfg.DialOption(nil, nil)
+	if err != nil {
+		t.Fatalf("failed to build dial options: %v", err)
+	}
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+
+	conn, err := grpc.Dial("bufconn", opts...)
+	if err != nil {
+		t.Fatalf("failed to dial in-memory server: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
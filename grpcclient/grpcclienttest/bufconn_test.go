@@ -0,0 +1,34 @@
+package grpcclienttest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestNewBufconnClientWithSnappyCompression(t *testing.T) {
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("test", grpc_health_v1.HealthCheckResponse_SERVING)
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:  1024 * 1024,
+		MaxSendMsgSize:  1024 * 1024,
+		GRPCCompression: "snappy",
+	}
+	require.NoError(t, cfg.Validate(nil))
+
+	conn := NewBufconnClient(t, cfg, func(s *grpc.Server) {
+		grpc_health_v1.RegisterHealthServer(s, healthServer)
+	})
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "test"})
+	require.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.Status)
+}
@@ -0,0 +1,50 @@
+package grpcclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// NewIdempotencyKey returns a unary interceptor which sets a random idempotency key in
+// the given outgoing metadata header of the calls to methods, so that the server can
+// dedupe the calls which are retried. The key is generated on the first attempt of a
+// call, and reused by the attempts retried by a backoff retry interceptor (see
+// NewBackoffRetry) further up the chain; calls with the header already set are left
+// untouched. Distinct calls get distinct keys.
+func NewIdempotencyKey(header string, methods ...string) grpc.UnaryClientInterceptor {
+	unsafe := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		unsafe[method] = struct{}{}
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if _, ok := unsafe[method]; !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(header)) > 0 {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		var key string
+		if call, ok := ctx.Value(retriedCallKey{}).(*retriedCall); ok {
+			if call.idempotencyKey == "" {
+				call.idempotencyKey = newIdempotencyKey()
+			}
+			key = call.idempotencyKey
+		} else {
+			key = newIdempotencyKey()
+		}
+		return invoker(metadata.AppendToOutgoingContext(ctx, header, key), method, req, reply, cc, opts...)
+	}
+}
+
+func newIdempotencyKey() string {
+	key := make([]byte, 16)
+	// crypto/rand.Read never fails on the supported platforms.
+	_, _ = rand.Read(key)
+	return hex.EncodeToString(key)
+}
@@ -0,0 +1,78 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	const header = "x-idempotency-key"
+
+	retryCfg := backoff.Config{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 5,
+	}
+
+	// recordingInvoker records the idempotency keys of each attempt, and rate limits the
+	// first two attempts of every call.
+	recordingInvoker := func(keys *[]string) grpc.UnaryInvoker {
+		attempts := 0
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			*keys = append(*keys, md.Get(header)...)
+			attempts++
+			if attempts%3 != 0 {
+				return status.Error(codes.ResourceExhausted, "slow down")
+			}
+			return nil
+		}
+	}
+
+	for name, chain := range map[string]grpc.UnaryClientInterceptor{
+		"key set after the backoff retry":  middleware.ChainUnaryClient(grpcclient.NewBackoffRetry(retryCfg), grpcclient.NewIdempotencyKey(header, "/test/Push")),
+		"key set before the backoff retry": middleware.ChainUnaryClient(grpcclient.NewIdempotencyKey(header, "/test/Push"), grpcclient.NewBackoffRetry(retryCfg)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var keys []string
+			invoker := recordingInvoker(&keys)
+
+			require.NoError(t, chain(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker))
+			require.Len(t, keys, 3)
+			assert.NotEmpty(t, keys[0])
+			assert.Equal(t, keys[0], keys[1])
+			assert.Equal(t, keys[0], keys[2])
+
+			// A distinct call gets a distinct key.
+			require.NoError(t, chain(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker))
+			require.Len(t, keys, 6)
+			assert.NotEqual(t, keys[0], keys[3])
+			assert.Equal(t, keys[3], keys[5])
+
+			// Methods which aren't allowed get no key.
+			require.NoError(t, chain(context.Background(), "/test/Query", nil, nil, &grpc.ClientConn{}, invoker))
+			assert.Len(t, keys, 6)
+		})
+	}
+
+	t.Run("key already set by the caller", func(t *testing.T) {
+		var keys []string
+		ctx := metadata.AppendToOutgoingContext(context.Background(), header, "caller-key")
+		chain := middleware.ChainUnaryClient(grpcclient.NewBackoffRetry(retryCfg), grpcclient.NewIdempotencyKey(header, "/test/Push"))
+
+		require.NoError(t, chain(ctx, "/test/Push", nil, nil, &grpc.ClientConn{}, recordingInvoker(&keys)))
+		assert.Equal(t, []string{"caller-key", "caller-key", "caller-key"}, keys)
+	})
+}
@@ -0,0 +1,37 @@
+package grpcclient
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+var (
+	unaryInterceptorsMtx sync.RWMutex
+	unaryInterceptors    = map[string]func(cfg Config) grpc.UnaryClientInterceptor{}
+)
+
+// RegisterUnaryInterceptor makes a UnaryClientInterceptor available by name, to be
+// selected through Config.Interceptors. The ctor is called with the config of each
+// connection using the interceptor. It panics if name is already registered.
+func RegisterUnaryInterceptor(name string, ctor func(cfg Config) grpc.UnaryClientInterceptor) {
+	unaryInterceptorsMtx.Lock()
+	defer unaryInterceptorsMtx.Unlock()
+
+	if _, ok := unaryInterceptors[name]; ok {
+		panic(fmt.Sprintf("grpcclient: interceptor %s registered twice", name))
+	}
+	unaryInterceptors[name] = ctor
+}
+
+func registeredUnaryInterceptor(name string) (func(cfg Config) grpc.UnaryClientInterceptor, error) {
+	unaryInterceptorsMtx.RLock()
+	defer unaryInterceptorsMtx.RUnlock()
+
+	ctor, ok := unaryInterceptors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown interceptor: %s", name)
+	}
+	return ctor, nil
+}
@@ -0,0 +1,70 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestRegisteredUnaryInterceptors(t *testing.T) {
+	var called []string
+	recorder := func(name string) grpc.UnaryClientInterceptor {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			called = append(called, name)
+			if name == "caller" {
+				return status.Error(codes.Unavailable, "not connected")
+			}
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+	}
+
+	var ctorCfg grpcclient.Config
+	grpcclient.RegisterUnaryInterceptor("test-registry-a", func(cfg grpcclient.Config) grpc.UnaryClientInterceptor {
+		ctorCfg = cfg
+		return recorder("a")
+	})
+	grpcclient.RegisterUnaryInterceptor("test-registry-b", func(cfg grpcclient.Config) grpc.UnaryClientInterceptor {
+		return recorder("b")
+	})
+	assert.Panics(t, func() {
+		grpcclient.RegisterUnaryInterceptor("test-registry-a", func(cfg grpcclient.Config) grpc.UnaryClientInterceptor { return nil })
+	})
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 1024,
+		MaxSendMsgSize: 1024,
+		Interceptors:   []string{"test-registry-b", "test-registry-a"},
+	}
+	require.NoError(t, cfg.Validate(nil))
+
+	opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{recorder("caller")}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1024, ctorCfg.MaxSendMsgSize)
+
+	conn, err := grpc.Dial("localhost:0", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = conn.Invoke(context.Background(), "/test/method", nil, nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, []string{"b", "a", "caller"}, called)
+}
+
+func TestUnknownRegisteredUnaryInterceptor(t *testing.T) {
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 1024,
+		MaxSendMsgSize: 1024,
+		Interceptors:   []string{"test-registry-unknown"},
+	}
+	assert.EqualError(t, cfg.Validate(nil), "unknown interceptor: test-registry-unknown")
+
+	_, err := cfg.DialOption(nil, nil)
+	assert.EqualError(t, err, "unknown interceptor: test-registry-unknown")
+}
@@ -0,0 +1,138 @@
+package grpcclient
+
+import (
+	"flag"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// minKeepaliveTime is the minimum keepalive time allowed by gRPC clients.
+	minKeepaliveTime = 10 * time.Second
+
+	// Default keepalive parameters, also used when the config isn't set through flags.
+	defaultKeepaliveTime    = 20 * time.Second
+	defaultKeepaliveTimeout = 10 * time.Second
+)
+
+// StreamKeepaliveConfig overrides the keepalive parameters of the clients used primarily
+// for streaming, see Config.StreamingClient, e.g. to detect dead servers faster on
+// long-lived streams. Unset values keep the client's keepalive parameters.
+type StreamKeepaliveConfig struct {
+	Time    time.Duration `yaml:"time"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// RegisterFlagsWithPrefix registers flags with prefix.
+func (cfg *StreamKeepaliveConfig) RegisterFlagsWithPrefix(prefix string, f *flag.FlagSet) {
+	f.DurationVar(&cfg.Time, prefix+".grpc-stream-keepalive-time", 0, "Keepalive time of streaming clients, overriding the keepalive time when the client is used primarily for streaming. Values lower than 10s, the minimum allowed by gRPC, are raised to 10s. 0 keeps the keepalive time.")
+	f.DurationVar(&cfg.Timeout, prefix+".grpc-stream-keepalive-timeout", 0, "Keepalive timeout of streaming clients, overriding the keepalive timeout when the client is used primarily for streaming. 0 keeps the keepalive timeout.")
+}
+
+// keepaliveParams returns the keepalive parameters used by the client: KeepaliveTime and
+// KeepaliveTimeout, or their defaults when they aren't set. The keepalive time is never
+// lower than minKeepaliveTime, so that servers don't close connections with GOAWAY for
+// pinging too often. When IdleTimeout is set, pings are sent after two thirds of it
+// without activity instead, and the connection is closed if they aren't acknowledged
+// within the remaining third. The StreamKeepalive values, if set, override both for
+// streaming clients.
+func (cfg *Config) keepaliveParams() keepalive.ClientParameters {
+	params := cfg.unaryKeepaliveParams()
+	if !cfg.StreamingClient {
+		return params
+	}
+
+	if cfg.StreamKeepalive.Time > 0 {
+		params.Time = cfg.StreamKeepalive.Time
+		if params.Time < minKeepaliveTime {
+			params.Time = minKeepaliveTime
+		}
+	}
+	if cfg.StreamKeepalive.Timeout > 0 {
+		params.Timeout = cfg.StreamKeepalive.Timeout
+	}
+	return params
+}
+
+// unaryKeepaliveParams returns the keepalive parameters of the clients not used primarily
+// for streaming.
+func (cfg *Config) unaryKeepaliveParams() keepalive.ClientParameters {
+	if cfg.IdleTimeout <= 0 {
+		keepaliveTime, keepaliveTimeout := cfg.KeepaliveTime, cfg.KeepaliveTimeout
+		if keepaliveTime <= 0 {
+			keepaliveTime = defaultKeepaliveTime
+		} else if keepaliveTime < minKeepaliveTime {
+			keepaliveTime = minKeepaliveTime
+		}
+		if keepaliveTimeout <= 0 {
+			keepaliveTimeout = defaultKeepaliveTimeout
+		}
+		return keepalive.ClientParameters{
+			Time:                keepaliveTime,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}
+	}
+
+	keepaliveTime := cfg.IdleTimeout * 2 / 3
+	if keepaliveTime < minKeepaliveTime {
+		keepaliveTime = minKeepaliveTime
+	}
+	return keepalive.ClientParameters{
+		Time:                keepaliveTime,
+		Timeout:             keepaliveTime / 2,
+		PermitWithoutStream: true,
+	}
+}
+
+// jitteredKeepaliveParams returns the keepalive parameters of a new connection: the
+// keepalive time is picked uniformly within KeepaliveJitter around its value.
+func (cfg *Config) jitteredKeepaliveParams() keepalive.ClientParameters {
+	params := cfg.keepaliveParams()
+	if cfg.KeepaliveJitter <= 0 {
+		return params
+	}
+
+	params.Time += time.Duration(rand.Int63n(int64(2*cfg.KeepaliveJitter)+1)) - cfg.KeepaliveJitter
+	if params.Time < minKeepaliveTime {
+		params.Time = minKeepaliveTime
+	}
+	return params
+}
+
+// ServerEnforcementPolicyFor returns a server-side keepalive enforcement policy which
+// accepts the keepalive pings sent by a client configured with cfg. The minimum time
+// between pings is set to half of the client's keepalive time, to leave some slack for
+// timing jitter, as a server receiving pings more often than MinTime closes the
+// connection with GOAWAY.
+func ServerEnforcementPolicyFor(cfg Config) keepalive.EnforcementPolicy {
+	params := cfg.keepaliveParams()
+
+	// Follow the shortest keepalive time of jittered connections.
+	minTime := params.Time - cfg.KeepaliveJitter
+	if cfg.KeepaliveJitter <= 0 {
+		minTime = params.Time
+	}
+	if minTime < minKeepaliveTime {
+		minTime = minKeepaliveTime
+	}
+	return keepalive.EnforcementPolicy{
+		MinTime:             minTime / 2,
+		PermitWithoutStream: params.PermitWithoutStream,
+	}
+}
+
+// ServerKeepaliveParams returns the keepalive parameters and enforcement policy of a
+// server paired with clients configured with cfg, so that both can be configured from the
+// same Config: the server pings idle clients as often as they ping it, and accepts their
+// pings, see ServerEnforcementPolicyFor. The connection age and idleness limits are
+// left unset.
+func (cfg Config) ServerKeepaliveParams() (keepalive.ServerParameters, keepalive.EnforcementPolicy) {
+	params := cfg.keepaliveParams()
+	return keepalive.ServerParameters{
+		Time:    params.Time,
+		Timeout: params.Timeout,
+	}, ServerEnforcementPolicyFor(cfg)
+}
@@ -0,0 +1,56 @@
+package grpcclient
+
+import (
+	"context"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"google.golang.org/grpc/stats"
+)
+
+type connTagInfoKey struct{}
+
+// keepaliveStatsHandler logs connection-level events at debug level. The gRPC stats
+// API doesn't expose individual keepalive pings or GOAWAY frames, but both end up
+// closing the transport, so logging the connection lifecycle together with the remote
+// address makes keepalive-induced reconnects observable.
+type keepaliveStatsHandler struct {
+	logger log.Logger
+}
+
+func newKeepaliveStatsHandler(logger log.Logger) *keepaliveStatsHandler {
+	return &keepaliveStatsHandler{logger: logger}
+}
+
+func (h *keepaliveStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (h *keepaliveStatsHandler) HandleRPC(context.Context, stats.RPCStats) {}
+
+func (h *keepaliveStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return context.WithValue(ctx, connTagInfoKey{}, info)
+}
+
+func (h *keepaliveStatsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {
+	remoteAddr := "unknown"
+	if info, ok := ctx.Value(connTagInfoKey{}).(*stats.ConnTagInfo); ok && info.RemoteAddr != nil {
+		remoteAddr = info.RemoteAddr.String()
+	}
+
+	var msg string
+	switch s.(type) {
+	case *stats.ConnBegin:
+		msg = "gRPC connection established"
+	case *stats.ConnEnd:
+		msg = "gRPC connection closed, possibly due to keepalive timeout or GOAWAY"
+	default:
+		return
+	}
+
+	keyvals := []interface{}{"msg", msg, "remote_addr", remoteAddr}
+	if label, ok := ChannelLabelFromContext(ctx); ok {
+		keyvals = append(keyvals, "channel", label)
+	}
+	level.Debug(h.logger).Log(keyvals...)
+}
@@ -0,0 +1,27 @@
+package grpcclient
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/stats"
+)
+
+func TestKeepaliveStatsHandler(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h := newKeepaliveStatsHandler(log.NewLogfmtLogger(buf))
+
+	ctx := h.TagConn(context.Background(), &stats.ConnTagInfo{
+		RemoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 9095},
+	})
+	h.HandleConn(ctx, &stats.ConnBegin{Client: true})
+	h.HandleConn(ctx, &stats.ConnEnd{Client: true})
+
+	output := buf.String()
+	assert.Contains(t, output, `msg="gRPC connection established" remote_addr=10.0.0.1:9095`)
+	assert.Contains(t, output, `msg="gRPC connection closed, possibly due to keepalive timeout or GOAWAY" remote_addr=10.0.0.1:9095`)
+}
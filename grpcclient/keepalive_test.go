@@ -0,0 +1,204 @@
+package grpcclient
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/keepalive"
+)
+
+func TestServerEnforcementPolicyFor(t *testing.T) {
+	cfg := Config{}
+	params := cfg.keepaliveParams()
+
+	policy := ServerEnforcementPolicyFor(cfg)
+	assert.LessOrEqual(t, int64(policy.MinTime), int64(params.Time))
+	assert.Positive(t, int64(policy.MinTime))
+	assert.Equal(t, params.PermitWithoutStream, policy.PermitWithoutStream)
+}
+
+func TestKeepaliveParamsWithIdleTimeout(t *testing.T) {
+	for name, test := range map[string]struct {
+		idleTimeout     time.Duration
+		expectedTime    time.Duration
+		expectedTimeout time.Duration
+	}{
+		"defaults":             {idleTimeout: 0, expectedTime: 20 * time.Second, expectedTimeout: 10 * time.Second},
+		"derived":              {idleTimeout: 3 * time.Minute, expectedTime: 2 * time.Minute, expectedTimeout: time.Minute},
+		"below the gRPC limit": {idleTimeout: 6 * time.Second, expectedTime: 10 * time.Second, expectedTimeout: 5 * time.Second},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := Config{IdleTimeout: test.idleTimeout}
+			params := cfg.keepaliveParams()
+			assert.Equal(t, test.expectedTime, params.Time)
+			assert.Equal(t, test.expectedTimeout, params.Timeout)
+			assert.True(t, params.PermitWithoutStream)
+
+			// The server enforcement policy follows the derived parameters.
+			assert.Equal(t, test.expectedTime/2, ServerEnforcementPolicyFor(cfg).MinTime)
+		})
+	}
+}
+
+func TestJitteredKeepaliveParams(t *testing.T) {
+	const jitter = 5 * time.Second
+	cfg := Config{KeepaliveJitter: jitter}
+	base := cfg.keepaliveParams()
+
+	minTime, maxTime := base.Time, base.Time
+	distinct := map[time.Duration]struct{}{}
+	for i := 0; i < 1000; i++ {
+		params := cfg.jitteredKeepaliveParams()
+		assert.GreaterOrEqual(t, int64(params.Time), int64(base.Time-jitter))
+		assert.LessOrEqual(t, int64(params.Time), int64(base.Time+jitter))
+		assert.Equal(t, base.Timeout, params.Timeout)
+
+		distinct[params.Time] = struct{}{}
+		if params.Time < minTime {
+			minTime = params.Time
+		}
+		if params.Time > maxTime {
+			maxTime = params.Time
+		}
+	}
+
+	// The keepalive times are spread across the band.
+	assert.Greater(t, len(distinct), 900)
+	assert.Less(t, int64(minTime), int64(base.Time-jitter/2))
+	assert.Greater(t, int64(maxTime), int64(base.Time+jitter/2))
+
+	// The server accepts the pings of the connections with the shortest keepalive time.
+	assert.Equal(t, (base.Time-jitter)/2, ServerEnforcementPolicyFor(cfg).MinTime)
+}
+
+func TestJitteredKeepaliveParamsRespectsTheGRPCLimit(t *testing.T) {
+	cfg := Config{KeepaliveJitter: time.Minute}
+	for i := 0; i < 100; i++ {
+		assert.GreaterOrEqual(t, int64(cfg.jitteredKeepaliveParams().Time), int64(minKeepaliveTime))
+	}
+	assert.Equal(t, minKeepaliveTime/2, ServerEnforcementPolicyFor(cfg).MinTime)
+}
+
+func TestKeepaliveParamsDefaults(t *testing.T) {
+	// A server policy commonly used with gRPC clients: pings are accepted every 10 seconds,
+	// also on connections without streams.
+	serverPolicy := keepalive.EnforcementPolicy{MinTime: 10 * time.Second, PermitWithoutStream: true}
+
+	var flagCfg Config
+	flagCfg.RegisterFlagsWithPrefix("test", flag.NewFlagSet("test", flag.PanicOnError))
+
+	for name, cfg := range map[string]Config{
+		"flag defaults": flagCfg,
+		"zero config":   {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			params := cfg.keepaliveParams()
+			assert.Equal(t, 20*time.Second, params.Time)
+			assert.Equal(t, 10*time.Second, params.Timeout)
+			assert.GreaterOrEqual(t, int64(params.Time), int64(serverPolicy.MinTime))
+			assert.True(t, !params.PermitWithoutStream || serverPolicy.PermitWithoutStream)
+		})
+	}
+
+	t.Run("keepalive time below the gRPC limit", func(t *testing.T) {
+		cfg := Config{KeepaliveTime: time.Second, KeepaliveTimeout: time.Second}
+		params := cfg.keepaliveParams()
+		assert.Equal(t, minKeepaliveTime, params.Time)
+		assert.Equal(t, time.Second, params.Timeout)
+		assert.GreaterOrEqual(t, int64(params.Time), int64(serverPolicy.MinTime))
+	})
+
+	t.Run("custom keepalive", func(t *testing.T) {
+		cfg := Config{KeepaliveTime: time.Minute, KeepaliveTimeout: 30 * time.Second}
+		params := cfg.keepaliveParams()
+		assert.Equal(t, time.Minute, params.Time)
+		assert.Equal(t, 30*time.Second, params.Timeout)
+		assert.Equal(t, 30*time.Second, ServerEnforcementPolicyFor(cfg).MinTime)
+	})
+}
+
+func TestKeepaliveParamsOfStreamingClients(t *testing.T) {
+	streamKeepalive := StreamKeepaliveConfig{Time: 15 * time.Second, Timeout: 2 * time.Second}
+
+	for name, test := range map[string]struct {
+		cfg             Config
+		expectedTime    time.Duration
+		expectedTimeout time.Duration
+	}{
+		"unary client": {
+			cfg:             Config{KeepaliveTime: time.Minute, KeepaliveTimeout: 20 * time.Second, StreamKeepalive: streamKeepalive},
+			expectedTime:    time.Minute,
+			expectedTimeout: 20 * time.Second,
+		},
+		"streaming client": {
+			cfg:             Config{KeepaliveTime: time.Minute, KeepaliveTimeout: 20 * time.Second, StreamingClient: true, StreamKeepalive: streamKeepalive},
+			expectedTime:    15 * time.Second,
+			expectedTimeout: 2 * time.Second,
+		},
+		"streaming client without overrides": {
+			cfg:             Config{KeepaliveTime: time.Minute, KeepaliveTimeout: 20 * time.Second, StreamingClient: true},
+			expectedTime:    time.Minute,
+			expectedTimeout: 20 * time.Second,
+		},
+		"streaming client overriding the timeout only": {
+			cfg:             Config{KeepaliveTime: time.Minute, StreamingClient: true, StreamKeepalive: StreamKeepaliveConfig{Timeout: time.Second}},
+			expectedTime:    time.Minute,
+			expectedTimeout: time.Second,
+		},
+		"streaming client below the gRPC limit": {
+			cfg:             Config{StreamingClient: true, StreamKeepalive: StreamKeepaliveConfig{Time: time.Second}},
+			expectedTime:    minKeepaliveTime,
+			expectedTimeout: defaultKeepaliveTimeout,
+		},
+		"streaming client with idle timeout": {
+			cfg:             Config{IdleTimeout: 3 * time.Minute, StreamingClient: true, StreamKeepalive: streamKeepalive},
+			expectedTime:    15 * time.Second,
+			expectedTimeout: 2 * time.Second,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			params := test.cfg.keepaliveParams()
+			assert.Equal(t, test.expectedTime, params.Time)
+			assert.Equal(t, test.expectedTimeout, params.Timeout)
+			assert.True(t, params.PermitWithoutStream)
+
+			// The server policy follows the keepalive time of the client.
+			assert.Equal(t, test.expectedTime/2, ServerEnforcementPolicyFor(test.cfg).MinTime)
+		})
+	}
+}
+
+func TestServerKeepaliveParams(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"defaults":                    {},
+		"custom keepalive":            {KeepaliveTime: time.Minute, KeepaliveTimeout: 30 * time.Second},
+		"below the gRPC limit":        {KeepaliveTime: time.Second},
+		"idle timeout":                {IdleTimeout: 3 * time.Minute},
+		"jitter":                      {KeepaliveTime: 30 * time.Second, KeepaliveJitter: 10 * time.Second},
+		"jitter below the gRPC limit": {KeepaliveTime: 15 * time.Second, KeepaliveJitter: 10 * time.Second},
+		"streaming client":            {StreamingClient: true, StreamKeepalive: StreamKeepaliveConfig{Time: 15 * time.Second, Timeout: time.Second}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			clientParams := cfg.keepaliveParams()
+			serverParams, policy := cfg.ServerKeepaliveParams()
+
+			assert.Equal(t, clientParams.Time, serverParams.Time)
+			assert.Equal(t, clientParams.Timeout, serverParams.Timeout)
+			assert.Equal(t, ServerEnforcementPolicyFor(cfg), policy)
+			assert.Equal(t, clientParams.PermitWithoutStream, policy.PermitWithoutStream)
+			assert.Positive(t, int64(policy.MinTime))
+
+			// No connection pings more often than the server enforces, even with jitter.
+			assert.LessOrEqual(t, int64(policy.MinTime), int64(clientParams.Time))
+			for i := 0; i < 100; i++ {
+				assert.LessOrEqual(t, int64(policy.MinTime), int64(cfg.jitteredKeepaliveParams().Time))
+			}
+		})
+	}
+
+	// It can be called on values which aren't addressable.
+	serverParams, _ := Config{KeepaliveTime: time.Minute}.ServerKeepaliveParams()
+	assert.Equal(t, time.Minute, serverParams.Time)
+}
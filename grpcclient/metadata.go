@@ -0,0 +1,51 @@
+package grpcclient
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// NewRequireMetadata creates interceptors which fail calls with codes.InvalidArgument,
+// without sending them, when any of the given keys is missing from the outgoing
+// metadata. They must come after any interceptor setting the metadata in the chain.
+// Without keys, the interceptors are no-ops.
+func NewRequireMetadata(keys ...string) (grpc.UnaryClientInterceptor, grpc.StreamClientInterceptor) {
+	required := make([]string, 0, len(keys))
+	for _, key := range keys {
+		// Metadata keys are case insensitive, and stored lowercase.
+		required = append(required, strings.ToLower(key))
+	}
+
+	unary := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := checkRequiredMetadata(ctx, required); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	stream := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := checkRequiredMetadata(ctx, required); err != nil {
+			return nil, err
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	return unary, stream
+}
+
+func checkRequiredMetadata(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	for _, key := range keys {
+		if len(md.Get(key)) == 0 {
+			return status.Errorf(codes.InvalidArgument, "missing required metadata: %s", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestRequireMetadata(t *testing.T) {
+	for name, test := range map[string]struct {
+		keys     []string
+		md       metadata.MD
+		expected codes.Code
+	}{
+		"all present": {
+			keys:     []string{"X-Scope-OrgID", "x-request-id"},
+			md:       metadata.Pairs("x-scope-orgid", "tenant", "x-request-id", "1"),
+			expected: codes.OK,
+		},
+		"one missing": {
+			keys:     []string{"X-Scope-OrgID", "x-request-id"},
+			md:       metadata.Pairs("x-request-id", "1"),
+			expected: codes.InvalidArgument,
+		},
+		"no metadata": {
+			keys:     []string{"X-Scope-OrgID"},
+			expected: codes.InvalidArgument,
+		},
+		"no requirements": {
+			keys:     nil,
+			expected: codes.OK,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.md != nil {
+				ctx = metadata.NewOutgoingContext(ctx, test.md)
+			}
+			unary, stream := grpcclient.NewRequireMetadata(test.keys...)
+
+			invoked := false
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				invoked = true
+				return nil
+			}
+			err := unary(ctx, "/test/method", nil, nil, &grpc.ClientConn{}, invoker)
+			assert.Equal(t, test.expected, status.Code(err))
+			assert.Equal(t, test.expected == codes.OK, invoked)
+
+			streamed := false
+			streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				streamed = true
+				return nil, nil
+			}
+			_, err = stream(ctx, &grpc.StreamDesc{}, &grpc.ClientConn{}, "/test/method", streamer)
+			assert.Equal(t, test.expected, status.Code(err))
+			assert.Equal(t, test.expected == codes.OK, streamed)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package grpcclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	rpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+)
+
+// ValidateMethodsAgainstServer uses the server reflection service to check that the
+// given full method names, e.g. "/package.Service/Method", exist on the server conn is
+// connected to. It's meant to catch typos in the per-method configuration at startup,
+// and it can only be used with servers supporting reflection. The returned error lists
+// the methods which weren't found.
+func ValidateMethodsAgainstServer(ctx context.Context, conn *grpc.ClientConn, methods []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := rpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to query server reflection: %w", err)
+	}
+
+	var notFound []string
+	for _, method := range methods {
+		symbol, ok := methodSymbol(method)
+		if !ok {
+			notFound = append(notFound, method)
+			continue
+		}
+
+		if err := stream.Send(&rpb.ServerReflectionRequest{
+			MessageRequest: &rpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: symbol},
+		}); err != nil {
+			return fmt.Errorf("failed to query server reflection: %w", err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented {
+				return fmt.Errorf("server reflection is not supported by the server: %w", err)
+			}
+			return fmt.Errorf("failed to query server reflection: %w", err)
+		}
+		if errResp := resp.GetErrorResponse(); errResp != nil {
+			if codes.Code(errResp.ErrorCode) != codes.NotFound {
+				return fmt.Errorf("failed to look up method %s with server reflection: %s", method, errResp.ErrorMessage)
+			}
+			notFound = append(notFound, method)
+		}
+	}
+	_ = stream.CloseSend()
+
+	if len(notFound) > 0 {
+		return fmt.Errorf("methods not found on the server: %s", strings.Join(notFound, ", "))
+	}
+	return nil
+}
+
+// methodSymbol returns the reflection symbol of the full method name, e.g.
+// "package.Service.Method" for "/package.Service/Method".
+func methodSymbol(method string) (string, bool) {
+	if !strings.HasPrefix(method, "/") {
+		return "", false
+	}
+	parts := strings.Split(method[1:], "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return parts[0] + "." + parts[1], true
+}
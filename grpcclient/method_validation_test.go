@@ -0,0 +1,57 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+
+	"github.com/grafana/dskit/grpcclient"
+	"github.com/grafana/dskit/grpcclient/grpcclienttest"
+)
+
+func TestValidateMethodsAgainstServer(t *testing.T) {
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 1024 * 1024,
+		MaxSendMsgSize: 1024 * 1024,
+	}
+	require.NoError(t, cfg.Validate(nil))
+
+	registerHealth := func(s *grpc.Server) {
+		grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	}
+
+	t.Run("server with reflection", func(t *testing.T) {
+		conn := grpcclienttest.NewBufconnClient(t, cfg, func(s *grpc.Server) {
+			registerHealth(s)
+			reflection.Register(s)
+		})
+
+		require.NoError(t, grpcclient.ValidateMethodsAgainstServer(context.Background(), conn, []string{
+			"/grpc.health.v1.Health/Check",
+			"/grpc.health.v1.Health/Watch",
+		}))
+		require.NoError(t, grpcclient.ValidateMethodsAgainstServer(context.Background(), conn, nil))
+
+		err := grpcclient.ValidateMethodsAgainstServer(context.Background(), conn, []string{
+			"/grpc.health.v1.Health/Check",
+			"/grpc.health.v1.Health/Chek",
+			"/grpc.health.v1.Helth/Check",
+			"grpc.health.v1.Health.Check",
+		})
+		require.EqualError(t, err, "methods not found on the server: /grpc.health.v1.Health/Chek, /grpc.health.v1.Helth/Check, grpc.health.v1.Health.Check")
+	})
+
+	t.Run("server without reflection", func(t *testing.T) {
+		conn := grpcclienttest.NewBufconnClient(t, cfg, registerHealth)
+
+		err := grpcclient.ValidateMethodsAgainstServer(context.Background(), conn, []string{"/grpc.health.v1.Health/Check"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "server reflection is not supported by the server")
+	})
+}
@@ -0,0 +1,19 @@
+package grpcclient
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registerOrExisting registers c with reg, or returns the equivalent collector already
+// registered with it. Interceptors register their metrics this way, so that the ones of
+// multiple connections, e.g. of the same client pool, can share a registerer: their
+// metrics are created unregistered, with promauto.With(nil).
+func registerOrExisting(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
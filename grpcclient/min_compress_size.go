@@ -0,0 +1,63 @@
+package grpcclient
+
+import (
+	"context"
+	"strconv"
+
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+)
+
+// minCompressSizeHeader is the metadata through which clients and servers advertise the
+// size below which they don't compress messages.
+const minCompressSizeHeader = "min-compress-size"
+
+// NewMinCompressSize creates a UnaryClientInterceptor which sends requests smaller than
+// minSize bytes uncompressed, and agrees on the threshold with the server: minSize is
+// advertised in the min-compress-size request metadata, and once a response carries the
+// server's threshold in its min-compress-size header, the larger of the two is used for
+// the calls issued afterwards. Requests whose size can't be measured are compressed
+// normally. It must come after any other interceptor choosing the compressor in the
+// chain.
+//
+// The threshold is kept per interceptor, which should be per connection: DialOption
+// creates a new one every time it's called.
+func NewMinCompressSize(minSize int) grpc.UnaryClientInterceptor {
+	advertised := strconv.Itoa(minSize)
+	threshold := atomic.NewInt64(int64(minSize))
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, minCompressSizeHeader, advertised)
+		if size, ok := messageSize(req); ok && int64(size) < threshold.Load() {
+			opts = withoutCompression(opts)
+		}
+
+		var header metadata.MD
+		opts = append(opts[:len(opts):len(opts)], grpc.Header(&header))
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if values := header.Get(minCompressSizeHeader); len(values) > 0 {
+			if serverSize, parseErr := strconv.ParseInt(values[0], 10, 64); parseErr == nil {
+				if serverSize < int64(minSize) {
+					serverSize = int64(minSize)
+				}
+				threshold.Store(serverSize)
+			}
+		}
+		return err
+	}
+}
+
+// messageSize returns the size of the protobuf encoding of msg, if it's a message.
+func messageSize(msg interface{}) (int, bool) {
+	if sizer, ok := msg.(interface{ Size() int }); ok {
+		return sizer.Size(), true
+	}
+	data, err := encoding.GetCodec(proto.Name).Marshal(msg)
+	if err != nil {
+		return 0, false
+	}
+	return len(data), true
+}
@@ -0,0 +1,106 @@
+package grpcclient_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestDialOptionWithMinCompressSize(t *testing.T) {
+	const clientMinSize = 50
+
+	for name, test := range map[string]struct {
+		serverMinSize string
+		service       string
+		expected      []string
+	}{
+		"server threshold larger": {
+			serverMinSize: "1000",
+			service:       strings.Repeat("x", 100),
+			// The first call is sent before the server advertises its threshold.
+			expected: []string{"snappy", "", ""},
+		},
+		"server threshold smaller": {
+			serverMinSize: "10",
+			service:       strings.Repeat("x", 20),
+			expected:      []string{"", "", ""},
+		},
+		"server threshold smaller, large requests": {
+			serverMinSize: "10",
+			service:       strings.Repeat("x", 100),
+			expected:      []string{"snappy", "snappy", "snappy"},
+		},
+		"server without threshold": {
+			service:  strings.Repeat("x", 20),
+			expected: []string{"", "", ""},
+		},
+		"invalid server threshold": {
+			serverMinSize: "large",
+			service:       strings.Repeat("x", 100),
+			expected:      []string{"snappy", "snappy", "snappy"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var advertised []string
+			listener := bufconn.Listen(1024 * 1024)
+			server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				md, _ := metadata.FromIncomingContext(ctx)
+				advertised = append(advertised, md.Get("min-compress-size")...)
+				if test.serverMinSize != "" {
+					if err := grpc.SetHeader(ctx, metadata.Pairs("min-compress-size", test.serverMinSize)); err != nil {
+						return nil, err
+					}
+				}
+				return handler(ctx, req)
+			}))
+			grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+			go func() {
+				_ = server.Serve(listener)
+			}()
+			defer server.Stop()
+
+			var compressors []string
+			recorder := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				compressor, _ := grpcclient.CompressorFromContext(ctx)
+				compressors = append(compressors, compressor)
+				return invoker(ctx, method, req, reply, cc, opts...)
+			}
+
+			cfg := grpcclient.Config{
+				MaxRecvMsgSize:  1024,
+				MaxSendMsgSize:  1024,
+				GRPCCompression: "snappy",
+				MinCompressSize: clientMinSize,
+			}
+			require.NoError(t, cfg.Validate(nil))
+			opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{recorder}, nil)
+			require.NoError(t, err)
+			opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}))
+
+			conn, err := grpc.Dial("bufconn", opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			client := grpc_health_v1.NewHealthClient(conn)
+			for i := 0; i < 3; i++ {
+				// The health server doesn't know the service, which doesn't matter here.
+				_, _ = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: test.service})
+			}
+			assert.Equal(t, test.expected, compressors)
+			assert.Equal(t, []string{"50", "50", "50"}, advertised)
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+)
+
+// contextDialer returns the dialer to connect to the server with, or nil to use the
+// default dialer of gRPC.
+func (cfg *Config) contextDialer() func(context.Context, string) (net.Conn, error) {
+	var dial func(context.Context, string) (net.Conn, error)
+	if cfg.AddressFamily != "" {
+		dial = newAddressFamilyDialer(cfg.AddressFamily)
+	}
+	if cfg.DisableNagle {
+		dial = newNoDelayDialer(dial)
+	}
+	return dial
+}
+
+// newNoDelayDialer returns a dialer disabling Nagle's algorithm (TCP_NODELAY) on the TCP
+// connections created by dial, or by a dialer of TCP connections if dial is nil.
+func newNoDelayDialer(dial func(context.Context, string) (net.Conn, error)) func(context.Context, string) (net.Conn, error) {
+	if dial == nil {
+		dial = newAddressFamilyDialer("tcp")
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			if err := tcpConn.SetNoDelay(true); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+}
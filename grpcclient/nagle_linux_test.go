@@ -0,0 +1,55 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNoDelayDialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	addr := listener.Addr().String()
+
+	// Go disables Nagle's algorithm on the connections it dials, so the dialed connection
+	// enables it for the dialer to have to disable it.
+	dial := newNoDelayDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		conn, err := newAddressFamilyDialer("tcp")(ctx, addr)
+		if err == nil {
+			err = conn.(*net.TCPConn).SetNoDelay(false)
+		}
+		return conn, err
+	})
+	conn, err := dial(context.Background(), addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+	var noDelay int
+	var sockoptErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		noDelay, sockoptErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	}))
+	require.NoError(t, sockoptErr)
+	assert.Equal(t, 1, noDelay)
+
+	// The option applies with or without an address family.
+	assert.NotNil(t, (&Config{DisableNagle: true}).contextDialer())
+	assert.NotNil(t, (&Config{DisableNagle: true, AddressFamily: addressFamilyIPv4}).contextDialer())
+	assert.Nil(t, (&Config{}).contextDialer())
+}
@@ -0,0 +1,120 @@
+package grpcclient
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/weaveworks/common/user"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// defaultPerTenantRateLimitMaxTenants is the number of tenants whose rate limiter is kept
+// when PerTenantRateLimitMaxTenants isn't set.
+const defaultPerTenantRateLimitMaxTenants = 10000
+
+// NewPerTenantRateLimiter creates a UnaryClientInterceptor rate limiting the calls of each
+// tenant independently, with the limit and burst of cfg.PerTenantRateLimit and
+// cfg.PerTenantRateLimitBurst. The tenant of a call is read from the X-Scope-OrgID
+// outgoing metadata or, if not set, from the org ID injected in its context. Calls
+// without a tenant are rate limited by cfg.RateLimiter, or RateLimit and RateLimitBurst,
+// and aren't rate limited if neither is set.
+//
+// Only the rate limiters of the cfg.PerTenantRateLimitMaxTenants most recently seen
+// tenants are kept: the limiter of a tenant seen again after being evicted starts with
+// a full burst. Calls fail like in NewRateLimiter, which rate limiters this interceptor
+// replaces further down the interceptor chain.
+func NewPerTenantRateLimiter(cfg *Config) grpc.UnaryClientInterceptor {
+	var global *RateLimiter
+	if cfg.RateLimiter != nil {
+		global = cfg.RateLimiter
+	} else if cfg.RateLimit > 0 {
+		global = NewTunableRateLimiter(cfg)
+	}
+
+	burst := cfg.PerTenantRateLimitBurst
+	if burst == 0 {
+		burst = int(cfg.PerTenantRateLimit)
+	}
+	maxTenants := cfg.PerTenantRateLimitMaxTenants
+	if maxTenants <= 0 {
+		maxTenants = defaultPerTenantRateLimitMaxTenants
+	}
+	limiters := &tenantRateLimiters{
+		limit:      rate.Limit(cfg.PerTenantRateLimit),
+		burst:      burst,
+		maxTenants: maxTenants,
+		waits:      rateLimitWaits(cfg.Registerer),
+		tenants:    map[string]*list.Element{},
+		lru:        list.New(),
+	}
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if ctx.Value(rateLimitedKey{}) != nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		limiter := global
+		if tenant := callTenant(ctx); tenant != "" {
+			limiter = limiters.get(tenant)
+		}
+		if limiter == nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		ctx = context.WithValue(ctx, rateLimitedKey{}, true)
+		if err := limiter.acquire(ctx); err != nil {
+			return err
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// callTenant returns the tenant of the call ctx belongs to, or an empty string.
+func callTenant(ctx context.Context) string {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if tenants := md.Get(user.OrgIDHeaderName); len(tenants) > 0 {
+		return tenants[0]
+	}
+	tenant, _ := user.ExtractOrgID(ctx)
+	return tenant
+}
+
+// tenantRateLimiters holds the rate limiters of the most recently seen tenants.
+type tenantRateLimiters struct {
+	limit      rate.Limit
+	burst      int
+	maxTenants int
+	waits      prometheus.Histogram // Nil if the wait times aren't tracked.
+
+	mu      sync.Mutex
+	tenants map[string]*list.Element // Of *tenantRateLimiter, in lru.
+	lru     *list.List               // Most recently seen tenants first.
+}
+
+type tenantRateLimiter struct {
+	tenant  string
+	limiter *RateLimiter
+}
+
+// get returns the rate limiter of tenant, creating it if needed.
+func (l *tenantRateLimiters) get(tenant string) *RateLimiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.tenants[tenant]; ok {
+		l.lru.MoveToFront(e)
+		return e.Value.(*tenantRateLimiter).limiter
+	}
+
+	limiter := newRateLimiter(l.limit, l.burst, l.waits)
+	l.tenants[tenant] = l.lru.PushFront(&tenantRateLimiter{tenant: tenant, limiter: limiter})
+	if l.lru.Len() > l.maxTenants {
+		oldest := l.lru.Remove(l.lru.Back()).(*tenantRateLimiter)
+		delete(l.tenants, oldest.tenant)
+	}
+	return limiter
+}
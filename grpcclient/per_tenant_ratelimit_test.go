@@ -0,0 +1,72 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/weaveworks/common/user"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestPerTenantRateLimiter(t *testing.T) {
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+
+	// call returns the code of a call issued with ctx: calls which would wait for a token fail
+	// with ResourceExhausted, as they would exceed the deadline.
+	call := func(ctx context.Context, limiter grpc.UnaryClientInterceptor) codes.Code {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		return status.Code(limiter(ctx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker))
+	}
+	withTenant := func(tenant string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "X-Scope-OrgID", tenant)
+	}
+
+	t.Run("tenants are throttled independently", func(t *testing.T) {
+		limiter := grpcclient.NewPerTenantRateLimiter(&grpcclient.Config{PerTenantRateLimit: 1, PerTenantRateLimitBurst: 1})
+
+		assert.Equal(t, codes.OK, call(withTenant("a"), limiter))
+		assert.Equal(t, codes.ResourceExhausted, call(withTenant("a"), limiter))
+		assert.Equal(t, codes.OK, call(withTenant("b"), limiter))
+		assert.Equal(t, codes.ResourceExhausted, call(withTenant("b"), limiter))
+
+		// The org ID injected in the context is used if the metadata isn't set.
+		assert.Equal(t, codes.OK, call(user.InjectOrgID(context.Background(), "c"), limiter))
+		assert.Equal(t, codes.ResourceExhausted, call(withTenant("c"), limiter))
+	})
+
+	t.Run("calls without tenant share the global limiter", func(t *testing.T) {
+		limiter := grpcclient.NewPerTenantRateLimiter(&grpcclient.Config{RateLimit: 1, RateLimitBurst: 1, PerTenantRateLimit: 1, PerTenantRateLimitBurst: 1})
+
+		assert.Equal(t, codes.OK, call(context.Background(), limiter))
+		assert.Equal(t, codes.ResourceExhausted, call(context.Background(), limiter))
+		assert.Equal(t, codes.OK, call(withTenant("a"), limiter))
+	})
+
+	t.Run("calls without tenant aren't throttled without global limit", func(t *testing.T) {
+		limiter := grpcclient.NewPerTenantRateLimiter(&grpcclient.Config{PerTenantRateLimit: 1, PerTenantRateLimitBurst: 1})
+
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, codes.OK, call(context.Background(), limiter))
+		}
+	})
+
+	t.Run("least recently seen tenants are evicted", func(t *testing.T) {
+		limiter := grpcclient.NewPerTenantRateLimiter(&grpcclient.Config{PerTenantRateLimit: 1, PerTenantRateLimitBurst: 1, PerTenantRateLimitMaxTenants: 1})
+
+		assert.Equal(t, codes.OK, call(withTenant("a"), limiter))
+		assert.Equal(t, codes.OK, call(withTenant("b"), limiter))
+
+		// The limiter of tenant a has been evicted, so it starts again with a full burst.
+		assert.Equal(t, codes.OK, call(withTenant("a"), limiter))
+	})
+}
@@ -0,0 +1,52 @@
+package grpcclient
+
+import (
+	"context"
+
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+)
+
+// preferredEncodingTrailer is the response trailer through which servers hint the
+// compressor they prefer the requests to be compressed with.
+const preferredEncodingTrailer = "preferred-encoding"
+
+// NewPreferredCompression creates a UnaryClientInterceptor which switches the compression
+// of calls to the compressor hinted by the server in the preferred-encoding trailer of a
+// response: the calls issued after the response are compressed with it, until another
+// hint is received. The "identity" compressor disables compression, and unregistered
+// compressors are ignored. Calls whose compressor is chosen by the interceptors further
+// down the chain, e.g. per method, keep it.
+//
+// The hint is kept per interceptor, which should be per connection: DialOption creates
+// a new one every time it's called.
+func NewPreferredCompression() grpc.UnaryClientInterceptor {
+	preferred := atomic.NewString("") // The last valid hint, empty until the first one.
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		var trailer metadata.MD
+		opts = append(opts[:len(opts):len(opts)], grpc.Trailer(&trailer))
+		if hint := preferred.Load(); hint != "" {
+			compressor, _ := preferredCompressor(hint)
+			opts = append(opts, grpc.UseCompressor(compressor))
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if hints := trailer.Get(preferredEncodingTrailer); len(hints) > 0 {
+			if _, ok := preferredCompressor(hints[0]); ok {
+				preferred.Store(hints[0])
+			}
+		}
+		return err
+	}
+}
+
+// preferredCompressor returns the compressor to use for the given hint, if registered.
+func preferredCompressor(hint string) (string, bool) {
+	if hint == encoding.Identity {
+		return "", true
+	}
+	return hint, encoding.GetCompressor(hint) != nil
+}
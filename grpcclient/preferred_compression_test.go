@@ -0,0 +1,82 @@
+package grpcclient_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+// hintingHealthServer hints the client to compress its requests with the given compressor.
+type hintingHealthServer struct {
+	*health.Server
+	hint string
+}
+
+func (s *hintingHealthServer) Check(ctx context.Context, req *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
+	if err := grpc.SetTrailer(ctx, metadata.Pairs("preferred-encoding", s.hint)); err != nil {
+		return nil, err
+	}
+	return s.Server.Check(ctx, req)
+}
+
+func TestDialOptionWithServerPreferredCompression(t *testing.T) {
+	for name, test := range map[string]struct {
+		hint     string
+		expected []string
+	}{
+		"registered compressor":   {hint: "gzip", expected: []string{"snappy", "gzip", "gzip"}},
+		"identity":                {hint: "identity", expected: []string{"snappy", "", ""}},
+		"unregistered compressor": {hint: "zstd", expected: []string{"snappy", "snappy", "snappy"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			listener := bufconn.Listen(1024 * 1024)
+			server := grpc.NewServer()
+			grpc_health_v1.RegisterHealthServer(server, &hintingHealthServer{Server: health.NewServer(), hint: test.hint})
+			go func() {
+				_ = server.Serve(listener)
+			}()
+			defer server.Stop()
+
+			var compressors []string
+			recorder := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				compressor, _ := grpcclient.CompressorFromContext(ctx)
+				compressors = append(compressors, compressor)
+				return invoker(ctx, method, req, reply, cc, opts...)
+			}
+
+			cfg := grpcclient.Config{
+				MaxRecvMsgSize:             1024,
+				MaxSendMsgSize:             1024,
+				GRPCCompression:            "snappy",
+				ServerPreferredCompression: true,
+			}
+			require.NoError(t, cfg.Validate(nil))
+			opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{recorder}, nil)
+			require.NoError(t, err)
+			opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+				return listener.Dial()
+			}))
+
+			conn, err := grpc.Dial("bufconn", opts...)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			client := grpc_health_v1.NewHealthClient(conn)
+			for i := 0; i < 3; i++ {
+				_, err = client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+				require.NoError(t, err)
+			}
+			assert.Equal(t, test.expected, compressors)
+		})
+	}
+}
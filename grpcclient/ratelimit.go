@@ -2,25 +2,156 @@ package grpcclient
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+type rateLimitedKey struct{}
+
 // NewRateLimiter creates a UnaryClientInterceptor for client side rate limiting.
+// If a rate limiter created by this function has already been applied to the call
+// further up the interceptor chain, the call is passed through without consuming
+// another token. Calls whose context is canceled before or while waiting for a
+// token fail with the corresponding context error code and don't consume a token.
+//
+// If cfg.Registerer is set, the time each call waits for a token is tracked by the
+// grpc_client_rate_limit_wait_seconds metric.
 func NewRateLimiter(cfg *Config) grpc.UnaryClientInterceptor {
+	return NewTunableRateLimiter(cfg).UnaryClientInterceptor()
+}
+
+// RateLimiter is a client side rate limiter whose limit and burst can be changed while
+// it's in use, e.g. when reloading the config, without recreating the connection.
+type RateLimiter struct {
+	limiter *rate.Limiter
+	waits   prometheus.Histogram // Nil if the wait times aren't tracked.
+
+	// now and wait are replaced in tests.
+	now  func() time.Time
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+// NewTunableRateLimiter creates a RateLimiter with the limit and burst of cfg. If
+// cfg.Registerer is set, the wait times are tracked as described in NewRateLimiter.
+func NewTunableRateLimiter(cfg *Config) *RateLimiter {
 	burst := cfg.RateLimitBurst
 	if burst == 0 {
 		burst = int(cfg.RateLimit)
 	}
-	limiter := rate.NewLimiter(rate.Limit(cfg.RateLimit), burst)
+
+	return newRateLimiter(rate.Limit(cfg.RateLimit), burst, rateLimitWaits(cfg.Registerer))
+}
+
+func newRateLimiter(limit rate.Limit, burst int, waits prometheus.Histogram) *RateLimiter {
+	return &RateLimiter{
+		limiter: rate.NewLimiter(limit, burst),
+		waits:   waits,
+		now:     time.Now,
+		wait:    waitFor,
+	}
+}
+
+// rateLimitWaits returns the histogram tracking the time calls wait for a token, or nil
+// if reg is nil.
+func rateLimitWaits(reg prometheus.Registerer) prometheus.Histogram {
+	if reg == nil {
+		return nil
+	}
+	return registerOrExisting(reg, promauto.With(nil).NewHistogram(prometheus.HistogramOpts{
+		Name:    "grpc_client_rate_limit_wait_seconds",
+		Help:    "Time calls waited for the client side rate limiter before proceeding.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+	})).(prometheus.Histogram)
+}
+
+// SetLimit changes the number of calls allowed per second. It's safe to call while
+// calls are rate limited.
+func (r *RateLimiter) SetLimit(limit rate.Limit) {
+	r.limiter.SetLimit(limit)
+}
+
+// SetBurst changes the maximum number of calls allowed at once. It's safe to call while
+// calls are rate limited.
+func (r *RateLimiter) SetBurst(burst int) {
+	r.limiter.SetBurst(burst)
+}
+
+// UnaryClientInterceptor returns the interceptor rate limiting calls, see NewRateLimiter.
+func (r *RateLimiter) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		err := limiter.Wait(ctx)
-		if err != nil {
-			return status.Error(codes.ResourceExhausted, err.Error())
+		if ctx.Value(rateLimitedKey{}) != nil {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+		ctx = context.WithValue(ctx, rateLimitedKey{}, true)
+
+		if err := r.acquire(ctx); err != nil {
+			return err
 		}
 		return invoker(ctx, method, req, reply, cc, opts...)
 	}
 }
+
+// acquire waits for a token, returning the error the call fails with if it can't get one.
+func (r *RateLimiter) acquire(ctx context.Context) error {
+	// Don't consume a token for a call which has already been canceled.
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+
+	if err := r.waitForToken(ctx); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return nil
+}
+
+// waitForToken works like rate.Limiter.Wait, also observing the time waited. If the
+// context is done while waiting, the reservation is canceled, giving the token back to
+// the limiter.
+func (r *RateLimiter) waitForToken(ctx context.Context) error {
+	now := r.now()
+	reservation := r.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return fmt.Errorf("rate: Wait(n=1) exceeds limiter's burst %d", r.limiter.Burst())
+	}
+
+	delay := reservation.DelayFrom(now)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(now.Add(delay)) {
+		reservation.CancelAt(now)
+		return fmt.Errorf("rate: Wait(n=1) would exceed context deadline")
+	}
+	if err := r.wait(ctx, delay); err != nil {
+		reservation.CancelAt(r.now())
+		return err
+	}
+
+	if r.waits != nil {
+		r.waits.Observe(delay.Seconds())
+	}
+	return nil
+}
+
+// waitFor waits for d, or until ctx is done.
+func waitFor(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,72 @@
+package grpcclient
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestRateLimiterWaitMetric(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	limiter := NewTunableRateLimiter(&Config{RateLimit: 10, RateLimitBurst: 1, Registerer: reg})
+
+	// Fake clock: waiting moves the time forward.
+	now := time.Unix(0, 0)
+	var waits []time.Duration
+	limiter.now = func() time.Time { return now }
+	limiter.wait = func(_ context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		now = now.Add(d)
+		return nil
+	}
+
+	interceptor := limiter.UnaryClientInterceptor()
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	call := func() {
+		require.NoError(t, interceptor(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker))
+	}
+
+	// The first call uses the burst, the next ones wait for a token every 100ms.
+	call()
+	call()
+	call()
+	// Once the limiter has been idle, calls don't wait anymore.
+	now = now.Add(time.Second)
+	call()
+
+	assert.Equal(t, []time.Duration{0, 100 * time.Millisecond, 100 * time.Millisecond, 0}, waits)
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP grpc_client_rate_limit_wait_seconds Time calls waited for the client side rate limiter before proceeding.
+		# TYPE grpc_client_rate_limit_wait_seconds histogram
+		grpc_client_rate_limit_wait_seconds_bucket{le="0.001"} 2
+		grpc_client_rate_limit_wait_seconds_bucket{le="0.004"} 2
+		grpc_client_rate_limit_wait_seconds_bucket{le="0.016"} 2
+		grpc_client_rate_limit_wait_seconds_bucket{le="0.064"} 2
+		grpc_client_rate_limit_wait_seconds_bucket{le="0.256"} 4
+		grpc_client_rate_limit_wait_seconds_bucket{le="1.024"} 4
+		grpc_client_rate_limit_wait_seconds_bucket{le="4.096"} 4
+		grpc_client_rate_limit_wait_seconds_bucket{le="16.384"} 4
+		grpc_client_rate_limit_wait_seconds_bucket{le="+Inf"} 4
+		grpc_client_rate_limit_wait_seconds_sum 0.2
+		grpc_client_rate_limit_wait_seconds_count 4
+	`)))
+}
+
+func TestRateLimiterWaitIsNotTrackedWithoutRegisterer(t *testing.T) {
+	limiter := NewTunableRateLimiter(&Config{RateLimit: 10, RateLimitBurst: 1})
+	assert.Nil(t, limiter.waits)
+
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	assert.NoError(t, limiter.UnaryClientInterceptor()(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker))
+}
@@ -3,8 +3,11 @@ package grpcclient_test
 import (
 	"context"
 	"testing"
+	"time"
 
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -34,3 +37,130 @@ func TestRateLimiterFailureResultsInResourceExhaustedError(t *testing.T) {
 		assert.Fail(t, "Could not convert error into expected Status type")
 	}
 }
+
+func TestRateLimiterIsNotAppliedTwiceInTheSameChain(t *testing.T) {
+	config := grpcclient.Config{
+		RateLimitBurst: 1,
+		RateLimit:      0.001,
+	}
+	invoker := func(currentCtx context.Context, currentMethod string, currentReq, currentRepl interface{}, currentConn *grpc.ClientConn, currentOpts ...grpc.CallOption) error {
+		return nil
+	}
+
+	limiter := grpcclient.NewRateLimiter(&config)
+	chain := middleware.ChainUnaryClient(limiter, limiter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// The burst allows a single call: if the limiter was applied twice, the nested
+	// invocation would have to wait far beyond the context deadline and fail.
+	assert.NoError(t, chain(ctx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker))
+}
+
+func TestRateLimiterDoesNotConsumeTokensForCanceledContexts(t *testing.T) {
+	config := grpcclient.Config{
+		RateLimitBurst: 1,
+		RateLimit:      0.001,
+	}
+	invoked := 0
+	invoker := func(currentCtx context.Context, currentMethod string, currentReq, currentRepl interface{}, currentConn *grpc.ClientConn, currentOpts ...grpc.CallOption) error {
+		invoked++
+		return nil
+	}
+	limiter := grpcclient.NewRateLimiter(&config)
+
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		err := limiter(canceledCtx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker)
+		assert.Equal(t, codes.Canceled, status.Code(err))
+	}
+	assert.Equal(t, 0, invoked)
+
+	// The only token in the burst must still be available.
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), time.Second)
+	defer cancelTimeout()
+	assert.NoError(t, limiter(ctx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker))
+	assert.Equal(t, 1, invoked)
+}
+
+func TestRateLimiterReturnsContextErrorWhenCanceledWhileWaiting(t *testing.T) {
+	config := grpcclient.Config{
+		RateLimitBurst: 1,
+		RateLimit:      1,
+	}
+	invoker := func(currentCtx context.Context, currentMethod string, currentReq, currentRepl interface{}, currentConn *grpc.ClientConn, currentOpts ...grpc.CallOption) error {
+		return nil
+	}
+	limiter := grpcclient.NewRateLimiter(&config)
+
+	// Consume the only token in the burst.
+	require.NoError(t, limiter(context.Background(), "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := limiter(ctx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func TestRateLimiterLimitCanBeChangedAtRuntime(t *testing.T) {
+	config := grpcclient.Config{
+		RateLimitBurst: 1,
+		RateLimit:      0.001,
+	}
+	invoker := func(currentCtx context.Context, currentMethod string, currentReq, currentRepl interface{}, currentConn *grpc.ClientConn, currentOpts ...grpc.CallOption) error {
+		return nil
+	}
+	limiter := grpcclient.NewTunableRateLimiter(&config)
+	interceptor := limiter.UnaryClientInterceptor()
+
+	call := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		return interceptor(ctx, "methodName", "", "expectedReply", &grpc.ClientConn{}, invoker)
+	}
+
+	// The burst is consumed, and the next token is far away.
+	require.NoError(t, call())
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call()))
+
+	// Raising the limit lets the following calls through.
+	limiter.SetLimit(1000)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, call())
+	}
+
+	// A zero burst rejects every call.
+	limiter.SetBurst(0)
+	err := call()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, err.Error(), "exceeds limiter's burst 0")
+}
+
+func TestDialOptionWithRateLimiter(t *testing.T) {
+	limiter := grpcclient.NewTunableRateLimiter(&grpcclient.Config{RateLimit: 0.001, RateLimitBurst: 1})
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize: 1024,
+		MaxSendMsgSize: 1024,
+		RateLimiter:    limiter,
+	}
+
+	var called int
+	opts, err := cfg.DialOption([]grpc.UnaryClientInterceptor{func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		called++
+		return nil
+	}}, nil)
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial("localhost:0", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.Invoke(context.Background(), "/test/method", nil, nil))
+
+	// The connection follows the limit changes.
+	limiter.SetBurst(0)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(conn.Invoke(context.Background(), "/test/method", nil, nil)))
+	assert.Equal(t, 1, called)
+}
@@ -0,0 +1,33 @@
+package grpcclient
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+
+	"github.com/grafana/dskit/backoff"
+)
+
+// NewReconnectRetry creates a UnaryClientInterceptor retrying calls failing with
+// codes.Unavailable, backing off according to cfg. Before each retry, the reconnection
+// backoff of the connection is reset, so that gRPC reconnects right away rather than
+// keeping the broken transport until its own backoff expires, and the retry can land on
+// a healthy backend. Otherwise, the retries work like the ones of NewBackoffRetry, which
+// can be used along with it.
+//
+// Use NewReconnectRetryWithRegisterer to track the retries in metrics.
+func NewReconnectRetry(cfg backoff.Config) grpc.UnaryClientInterceptor {
+	return newRetry(codes.Unavailable, resetConnectBackoff, cfg, nil, nil)
+}
+
+// NewReconnectRetryWithRegisterer works like NewReconnectRetry, and if reg is not nil,
+// the number of retries and of attempts per call are tracked by the
+// grpc_client_reconnect_retries_total and grpc_client_reconnect_attempts_per_call
+// metrics. Interceptors created with the same registerer share them.
+func NewReconnectRetryWithRegisterer(cfg backoff.Config, reg prometheus.Registerer) grpc.UnaryClientInterceptor {
+	return newRetry(codes.Unavailable, resetConnectBackoff, cfg, nil, newRetryMetrics(reg, "reconnect", "unavailable servers"))
+}
+
+func resetConnectBackoff(cc *grpc.ClientConn) {
+	cc.ResetConnectBackoff()
+}
@@ -0,0 +1,107 @@
+package grpcclient_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestReconnectRetry(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	cfg := grpcclient.Config{
+		MaxRecvMsgSize:       1024,
+		MaxSendMsgSize:       1024,
+		BackoffOnUnavailable: true,
+		BackoffConfig: backoff.Config{
+			MinBackoff: 10 * time.Millisecond,
+			MaxBackoff: 10 * time.Millisecond,
+			MaxRetries: 5,
+		},
+	}
+	opts, err := cfg.DialOption(nil, nil)
+	require.NoError(t, err)
+
+	// The backend is unreachable on the first connection attempt, and recovers after.
+	dials := atomic.NewInt32(0)
+	opts = append(opts, grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		if dials.Inc() == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return listener.Dial()
+	}))
+
+	conn, err := grpc.Dial("bufconn", opts...)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Without resetting the connection backoff, gRPC would wait at least 800ms before
+	// reconnecting, and all the retries would fail.
+	start := time.Now()
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+	assert.Equal(t, int32(2), dials.Load())
+}
+
+func TestReconnectRetryWithBackoffRetry(t *testing.T) {
+	var errs []codes.Code
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		switch len(errs) {
+		case 0, 2:
+			errs = append(errs, codes.Unavailable)
+		case 1, 3:
+			errs = append(errs, codes.ResourceExhausted)
+		default:
+			return nil
+		}
+		return status.Error(errs[len(errs)-1], "failed")
+	}
+
+	cfg := backoff.Config{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 5,
+	}
+	reg := prometheus.NewPedanticRegistry()
+	// Each interceptor retries its own errors, and is not applied twice.
+	chain := middleware.ChainUnaryClient(
+		grpcclient.NewBackoffRetry(cfg),
+		grpcclient.NewReconnectRetryWithRegisterer(cfg, reg),
+		grpcclient.NewReconnectRetry(cfg),
+	)
+
+	require.NoError(t, chain(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, invoker))
+	assert.Equal(t, []codes.Code{codes.Unavailable, codes.ResourceExhausted, codes.Unavailable, codes.ResourceExhausted}, errs)
+
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(`
+		# HELP grpc_client_reconnect_retries_total Total number of calls retried after backing off because of unavailable servers.
+		# TYPE grpc_client_reconnect_retries_total counter
+		grpc_client_reconnect_retries_total{method="/test/Push"} 2
+	`), "grpc_client_reconnect_retries_total"))
+}
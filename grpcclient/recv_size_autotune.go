@@ -0,0 +1,93 @@
+package grpcclient
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+)
+
+// autoTuneRecvSizeMemoryFraction is the fraction of the available memory which the
+// messages received by concurrent requests may use, when auto-tuning MaxRecvMsgSize.
+const autoTuneRecvSizeMemoryFraction = 0.5
+
+// availableMemory returns the memory available to the process in bytes. It's replaced
+// in tests.
+var availableMemory = readAvailableMemory
+
+// AutoTune lowers MaxRecvMsgSize so that MaxConcurrentRequests messages of that size fit
+// in a fraction of the available memory, and logs the chosen value. It does nothing unless
+// AutoTuneRecvSize is set, and is meant to be called once at startup, after Validate and
+// before DialOption.
+func (cfg *Config) AutoTune(logger log.Logger) {
+	if !cfg.AutoTuneRecvSize || cfg.MaxConcurrentRequests <= 0 {
+		return
+	}
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	memory, err := availableMemory()
+	if err != nil {
+		level.Warn(logger).Log("msg", "unable to read the available memory, not auto-tuning the gRPC client max receive message size", "err", err)
+		return
+	}
+
+	limit := uint64(float64(memory)*autoTuneRecvSizeMemoryFraction) / uint64(cfg.MaxConcurrentRequests)
+	if limit < uint64(cfg.MaxRecvMsgSize) {
+		cfg.MaxRecvMsgSize = int(limit)
+	}
+	level.Info(logger).Log("msg", "auto-tuned the gRPC client max receive message size", "max_recv_msg_size", cfg.MaxRecvMsgSize, "available_memory", memory, "max_concurrent_requests", cfg.MaxConcurrentRequests)
+}
+
+// readAvailableMemory returns the memory limit of the cgroup of the process, or the
+// total memory of the host if lower or if the cgroup isn't limited.
+func readAvailableMemory() (uint64, error) {
+	memory, err := readMemTotal("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	// cgroup v2, then v1. Unlimited cgroups report "max" or a value above the host memory.
+	for _, path := range []string{"/sys/fs/cgroup/memory.max", "/sys/fs/cgroup/memory/memory.limit_in_bytes"} {
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if limit, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err == nil && limit < memory {
+			memory = limit
+		}
+		break
+	}
+	return memory, nil
+}
+
+// readMemTotal returns the MemTotal of the given meminfo file, in bytes.
+func readMemTotal(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemTotal in %s: %w", path, err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("MemTotal not found in %s", path)
+}
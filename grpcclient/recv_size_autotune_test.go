@@ -0,0 +1,81 @@
+package grpcclient
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutoTuneRecvSize(t *testing.T) {
+	defer func(original func() (uint64, error)) { availableMemory = original }(availableMemory)
+
+	for name, test := range map[string]struct {
+		memory      uint64
+		memoryErr   error
+		maxRecvSize int
+		concurrency int
+		expected    int
+	}{
+		"clamped to the available memory": {
+			memory:      1 << 30,
+			maxRecvSize: 100 << 20,
+			concurrency: 16,
+			expected:    32 << 20,
+		},
+		"not raised above the configured size": {
+			memory:      64 << 30,
+			maxRecvSize: 100 << 20,
+			concurrency: 16,
+			expected:    100 << 20,
+		},
+		"unchanged if the memory can't be read": {
+			memoryErr:   errors.New("no meminfo"),
+			maxRecvSize: 100 << 20,
+			concurrency: 16,
+			expected:    100 << 20,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			availableMemory = func() (uint64, error) { return test.memory, test.memoryErr }
+
+			var logs bytes.Buffer
+			cfg := Config{MaxRecvMsgSize: test.maxRecvSize, AutoTuneRecvSize: true, MaxConcurrentRequests: test.concurrency}
+			require.NoError(t, cfg.Validate(nil))
+			assert.Equal(t, test.maxRecvSize, cfg.MaxRecvMsgSize, "Validate must not change the config")
+
+			cfg.AutoTune(log.NewLogfmtLogger(&logs))
+			assert.Equal(t, test.expected, cfg.MaxRecvMsgSize)
+			if test.memoryErr == nil {
+				assert.Contains(t, logs.String(), "auto-tuned the gRPC client max receive message size")
+			}
+		})
+	}
+
+	t.Run("requires a positive concurrency", func(t *testing.T) {
+		cfg := Config{MaxRecvMsgSize: 100 << 20, AutoTuneRecvSize: true}
+		require.Error(t, cfg.Validate(nil))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		availableMemory = func() (uint64, error) { return 1 << 30, nil }
+
+		cfg := Config{MaxRecvMsgSize: 100 << 20, MaxConcurrentRequests: 16}
+		cfg.AutoTune(nil)
+		assert.Equal(t, 100<<20, cfg.MaxRecvMsgSize)
+	})
+}
+
+func TestReadMemTotal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "meminfo")
+	require.NoError(t, ioutil.WriteFile(path, []byte("MemTotal:        2048 kB\nMemFree:         1024 kB\n"), 0600))
+
+	memory, err := readMemTotal(path)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2<<20), memory)
+}
@@ -0,0 +1,53 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/stats"
+)
+
+// recvSizeWarningInterval is the minimum time between two warnings about the size of
+// received messages.
+const recvSizeWarningInterval = time.Minute
+
+// recvSizeWarningStatsHandler logs a warning when a received message is larger than a
+// fraction of the max receive message size.
+type recvSizeWarningStatsHandler struct {
+	logger    log.Logger
+	threshold int
+	maxSize   int
+	limiter   *rate.Limiter
+}
+
+func newRecvSizeWarningStatsHandler(logger log.Logger, threshold float64, maxSize int) *recvSizeWarningStatsHandler {
+	return &recvSizeWarningStatsHandler{
+		logger:    logger,
+		threshold: int(threshold * float64(maxSize)),
+		maxSize:   maxSize,
+		limiter:   rate.NewLimiter(rate.Every(recvSizeWarningInterval), 1),
+	}
+}
+
+func (h *recvSizeWarningStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcMethodKey{}, info.FullMethodName)
+}
+
+func (h *recvSizeWarningStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	payload, ok := s.(*stats.InPayload)
+	if !ok || payload.Length <= h.threshold || !h.limiter.Allow() {
+		return
+	}
+
+	method, _ := ctx.Value(rpcMethodKey{}).(string)
+	level.Warn(h.logger).Log("msg", "received gRPC message approaching the max receive message size", "method", method, "size", payload.Length, "max_size", h.maxSize)
+}
+
+func (h *recvSizeWarningStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *recvSizeWarningStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
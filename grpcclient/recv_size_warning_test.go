@@ -0,0 +1,44 @@
+package grpcclient
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/stats"
+)
+
+func TestRecvSizeWarningStatsHandler(t *testing.T) {
+	receive := func(h *recvSizeWarningStatsHandler, size int) {
+		ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: "/test/Query"})
+		h.HandleRPC(ctx, &stats.InPayload{Length: size})
+	}
+
+	t.Run("message below the threshold", func(t *testing.T) {
+		var buf bytes.Buffer
+		h := newRecvSizeWarningStatsHandler(log.NewLogfmtLogger(&buf), 0.8, 1000)
+
+		receive(h, 800)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("messages above the threshold", func(t *testing.T) {
+		var buf bytes.Buffer
+		h := newRecvSizeWarningStatsHandler(log.NewLogfmtLogger(&buf), 0.8, 1000)
+
+		// The warnings are rate limited.
+		receive(h, 801)
+		receive(h, 900)
+		assert.Equal(t, "level=warn msg=\"received gRPC message approaching the max receive message size\" method=/test/Query size=801 max_size=1000\n", buf.String())
+	})
+}
+
+func TestConfigValidateRecvSizeWarnThreshold(t *testing.T) {
+	cfg := Config{RecvSizeWarnThreshold: 0.9}
+	assert.NoError(t, cfg.Validate(nil))
+
+	cfg.RecvSizeWarnThreshold = 1.5
+	assert.EqualError(t, cfg.Validate(nil), "gRPC client receive size warning threshold must be between 0 and 1, got 1.5")
+}
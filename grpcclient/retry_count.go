@@ -0,0 +1,34 @@
+package grpcclient
+
+import (
+	"context"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/grafana/dskit/backoff"
+)
+
+// RetryCountHeader is the outgoing metadata header set by NewRetryCount.
+const RetryCountHeader = "x-client-retry-count"
+
+// NewRetryCount returns a unary interceptor which sets the number of retries made so far
+// by a backoff retry interceptor (see NewBackoffRetry) further up the chain in the
+// x-client-retry-count outgoing metadata of each attempt: 0 on the first attempt, 1 on
+// the first retry and so on, so that the attempt which succeeds tells the server how
+// many retries the call took. Calls not retried by a backoff retry interceptor are left
+// untouched.
+func NewRetryCount() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		attempt, ok := backoff.AttemptFromContext(ctx)
+		if !ok {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		md.Set(RetryCountHeader, strconv.Itoa(attempt-1))
+		return invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,83 @@
+package grpcclient_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestRetryCount(t *testing.T) {
+	retry := grpcclient.NewBackoffRetry(backoff.Config{
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		MaxRetries: 5,
+	})
+	chain := middleware.ChainUnaryClient(retry, grpcclient.NewRetryCount())
+
+	// rateLimitedInvoker records the retry count metadata of each attempt, and rate limits
+	// the first failures attempts.
+	rateLimitedInvoker := func(failures int, counts *[][]string) grpc.UnaryInvoker {
+		return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			*counts = append(*counts, md.Get(grpcclient.RetryCountHeader))
+			if len(*counts) <= failures {
+				return status.Error(codes.ResourceExhausted, "slow down")
+			}
+			return nil
+		}
+	}
+
+	t.Run("retried call", func(t *testing.T) {
+		var counts [][]string
+		require.NoError(t, chain(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, rateLimitedInvoker(2, &counts)))
+		assert.Equal(t, [][]string{{"0"}, {"1"}, {"2"}}, counts)
+	})
+
+	t.Run("call succeeding at the first attempt", func(t *testing.T) {
+		var counts [][]string
+		require.NoError(t, chain(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, rateLimitedInvoker(0, &counts)))
+		assert.Equal(t, [][]string{{"0"}}, counts)
+	})
+
+	t.Run("header set by the caller", func(t *testing.T) {
+		var counts [][]string
+		ctx := metadata.AppendToOutgoingContext(context.Background(), grpcclient.RetryCountHeader, "5", "other", "value")
+		require.NoError(t, chain(ctx, "/test/Push", nil, nil, &grpc.ClientConn{}, rateLimitedInvoker(1, &counts)))
+		assert.Equal(t, [][]string{{"0"}, {"1"}}, counts)
+
+		// The caller's metadata isn't modified.
+		md, _ := metadata.FromOutgoingContext(ctx)
+		assert.Equal(t, []string{"5"}, md.Get(grpcclient.RetryCountHeader))
+		assert.Equal(t, []string{"value"}, md.Get("other"))
+	})
+
+	t.Run("without backoff retry", func(t *testing.T) {
+		var counts [][]string
+		require.NoError(t, grpcclient.NewRetryCount()(context.Background(), "/test/Push", nil, nil, &grpc.ClientConn{}, rateLimitedInvoker(0, &counts)))
+		assert.Equal(t, [][]string{nil}, counts)
+	})
+}
+
+func TestDialOptionWithBackoffRetryCountMetadata(t *testing.T) {
+	cfg := grpcclient.Config{
+		BackoffOnRatelimits:       true,
+		BackoffRetryCountMetadata: true,
+		BackoffConfig:             backoff.Config{MinBackoff: time.Millisecond, MaxBackoff: time.Second, MaxRetries: 3},
+	}
+	assert.Equal(t, []string{"backoff_retry", "retry_count"}, cfg.ActiveInterceptorNames())
+
+	// Without the backoff on rate limits, there are no retries to count.
+	cfg.BackoffOnRatelimits = false
+	assert.Empty(t, cfg.ActiveInterceptorNames())
+}
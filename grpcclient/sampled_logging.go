@@ -0,0 +1,46 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+)
+
+// NewSampledLogger creates a UnaryClientInterceptor logging the method and duration of
+// calls: every failed call is logged at warning level, but only the given fraction,
+// between 0 and 1, of the successful ones is logged, at debug level. Successful calls are
+// sampled evenly, e.g. one out of ten with a rate of 0.1, using a counter shared by all
+// the calls.
+func NewSampledLogger(logger log.Logger, successSampleRate float64) grpc.UnaryClientInterceptor {
+	successes := atomic.NewUint64(0)
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err != nil {
+			level.Warn(logger).Log("msg", "gRPC call failed", "method", method, "duration", time.Since(start), "err", err)
+			return err
+		}
+
+		if sampleSuccess(successes.Inc(), successSampleRate) {
+			level.Debug(logger).Log("msg", "gRPC call succeeded", "method", method, "duration", time.Since(start))
+		}
+		return nil
+	}
+}
+
+// sampleSuccess returns whether the n-th successful call, starting from 1, is sampled:
+// it is when n*rate reaches a new integer, so that a rate fraction of the calls is.
+func sampleSuccess(n uint64, rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	if rate >= 1 {
+		return true
+	}
+	return uint64(float64(n)*rate) != uint64(float64(n-1)*rate)
+}
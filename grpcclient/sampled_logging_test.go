@@ -0,0 +1,78 @@
+package grpcclient_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestSampledLogger(t *testing.T) {
+	const calls = 1000
+
+	for name, test := range map[string]struct {
+		rate              float64
+		expectedSuccesses int
+	}{
+		"no successes":  {rate: 0, expectedSuccesses: 0},
+		"10% successes": {rate: 0.1, expectedSuccesses: calls / 10},
+		"a third":       {rate: 1. / 3, expectedSuccesses: calls / 3},
+		"all successes": {rate: 1, expectedSuccesses: calls},
+	} {
+		t.Run(name, func(t *testing.T) {
+			errorLogs, successLogs := atomic.NewInt32(0), atomic.NewInt32(0)
+			logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+				line := fmtKeyvals(keyvals)
+				assert.Contains(t, line, "method=/test/")
+				assert.Contains(t, line, "duration=")
+				if strings.Contains(line, "err=") {
+					errorLogs.Inc()
+				} else {
+					successLogs.Inc()
+				}
+				return nil
+			})
+
+			interceptor := grpcclient.NewSampledLogger(logger, test.rate)
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				if method == "/test/Fail" {
+					return errors.New("failed")
+				}
+				return nil
+			}
+
+			// Calls are logged concurrently.
+			wg := sync.WaitGroup{}
+			for i := 0; i < calls; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					assert.NoError(t, interceptor(context.Background(), "/test/Succeed", nil, nil, &grpc.ClientConn{}, invoker))
+				}()
+				go func() {
+					defer wg.Done()
+					assert.Error(t, interceptor(context.Background(), "/test/Fail", nil, nil, &grpc.ClientConn{}, invoker))
+				}()
+			}
+			wg.Wait()
+
+			assert.Equal(t, int32(calls), errorLogs.Load())
+			assert.InDelta(t, test.expectedSuccesses, successLogs.Load(), 1)
+		})
+	}
+}
+
+// fmtKeyvals formats keyvals as a logfmt line.
+func fmtKeyvals(keyvals []interface{}) string {
+	var sb strings.Builder
+	_ = log.NewLogfmtLogger(&sb).Log(keyvals...)
+	return sb.String()
+}
@@ -0,0 +1,28 @@
+package grpcclient
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+var errServiceConfigValidationDial = errors.New("service config validation doesn't connect")
+
+// validateServiceConfig checks serviceConfigJSON with the gRPC service config parser.
+// gRPC doesn't expose the parser, but runs it when creating a connection, so it creates
+// one which never connects.
+func validateServiceConfig(serviceConfigJSON string) error {
+	conn, err := grpc.Dial("passthrough:///service-config-validation",
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(serviceConfigJSON),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+			return nil, errServiceConfigValidationDial
+		}),
+	)
+	if err != nil {
+		return errors.Wrap(err, "invalid service config JSON")
+	}
+	return conn.Close()
+}
@@ -0,0 +1,53 @@
+package grpcclient
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/stats"
+)
+
+type rpcMethodKey struct{}
+
+// sizeStatsHandler tracks the wire size of the messages sent and received, by method.
+type sizeStatsHandler struct {
+	requestSize  *prometheus.HistogramVec
+	responseSize *prometheus.HistogramVec
+}
+
+func newSizeStatsHandler(reg prometheus.Registerer) *sizeStatsHandler {
+	return &sizeStatsHandler{
+		requestSize: registerOrExisting(reg, promauto.With(nil).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_client_request_size_bytes",
+			Help:    "Size on the wire of the messages sent by the client.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 9),
+		}, []string{"method"})).(*prometheus.HistogramVec),
+		responseSize: registerOrExisting(reg, promauto.With(nil).NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_client_response_size_bytes",
+			Help:    "Size on the wire of the messages received by the client.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 9),
+		}, []string{"method"})).(*prometheus.HistogramVec),
+	}
+}
+
+func (h *sizeStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, rpcMethodKey{}, info.FullMethodName)
+}
+
+func (h *sizeStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	method, _ := ctx.Value(rpcMethodKey{}).(string)
+
+	switch s := s.(type) {
+	case *stats.OutPayload:
+		h.requestSize.WithLabelValues(method).Observe(float64(s.WireLength))
+	case *stats.InPayload:
+		h.responseSize.WithLabelValues(method).Observe(float64(s.WireLength))
+	}
+}
+
+func (h *sizeStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *sizeStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,38 @@
+package grpcclient
+
+import (
+	"context"
+
+	"google.golang.org/grpc/stats"
+)
+
+// multiStatsHandler combines stats handlers, as a connection can only have one. The
+// contexts returned by the Tag methods are passed on to the following handlers, so a
+// handler can see the tags of the handlers before it.
+type multiStatsHandler []stats.Handler
+
+func (m multiStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	for _, h := range m {
+		ctx = h.TagRPC(ctx, info)
+	}
+	return ctx
+}
+
+func (m multiStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	for _, h := range m {
+		h.HandleRPC(ctx, s)
+	}
+}
+
+func (m multiStatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	for _, h := range m {
+		ctx = h.TagConn(ctx, info)
+	}
+	return ctx
+}
+
+func (m multiStatsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {
+	for _, h := range m {
+		h.HandleConn(ctx, s)
+	}
+}
@@ -0,0 +1,28 @@
+package grpcclient
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatusCode returns the gRPC status code of err, looking through wrapped errors, e.g.
+// the ones returned by the backoff retry interceptor. It returns codes.OK for a nil
+// error and codes.Unknown for errors which don't carry a gRPC status.
+func StatusCode(err error) codes.Code {
+	if err == nil {
+		return codes.OK
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code()
+	}
+
+	var se interface {
+		GRPCStatus() *status.Status
+	}
+	if errors.As(err, &se) {
+		return se.GRPCStatus().Code()
+	}
+	return codes.Unknown
+}
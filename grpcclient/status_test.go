@@ -0,0 +1,29 @@
+package grpcclient_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+func TestStatusCode(t *testing.T) {
+	for name, test := range map[string]struct {
+		err      error
+		expected codes.Code
+	}{
+		"nil error":      {err: nil, expected: codes.OK},
+		"status error":   {err: status.Error(codes.ResourceExhausted, "rate limited"), expected: codes.ResourceExhausted},
+		"wrapped status": {err: fmt.Errorf("call failed: %w", status.Error(codes.Unavailable, "not connected")), expected: codes.Unavailable},
+		"plain error":    {err: errors.New("something went wrong"), expected: codes.Unknown},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, test.expected, grpcclient.StatusCode(test.err))
+		})
+	}
+}
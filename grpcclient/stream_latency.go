@@ -0,0 +1,77 @@
+package grpcclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+)
+
+// NewStreamLatencyInstrumentation creates a StreamClientInterceptor tracking, by method,
+// the time until the first message of a stream is received, separately from the total
+// duration of the stream, with the grpc_client_stream_first_message_duration_seconds and
+// grpc_client_stream_duration_seconds metrics. The former measures connection and
+// queueing latency, while the latter also depends on the streaming throughput. Streams
+// aren't tracked if reg is nil.
+func NewStreamLatencyInstrumentation(reg prometheus.Registerer) grpc.StreamClientInterceptor {
+	if reg == nil {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			return streamer(ctx, desc, cc, method, opts...)
+		}
+	}
+
+	firstMessage := registerOrExisting(reg, promauto.With(nil).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_client_stream_first_message_duration_seconds",
+		Help:    "Time from the creation of a stream until its first message is received.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})).(*prometheus.HistogramVec)
+	total := registerOrExisting(reg, promauto.With(nil).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_client_stream_duration_seconds",
+		Help:    "Time from the creation of a stream until it's done.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})).(*prometheus.HistogramVec)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &latencyClientStream{
+			ClientStream: stream,
+			start:        start,
+			firstMessage: firstMessage.WithLabelValues(method),
+			total:        total.WithLabelValues(method),
+		}, nil
+	}
+}
+
+type latencyClientStream struct {
+	grpc.ClientStream
+	start        time.Time
+	firstMessage prometheus.Observer
+	total        prometheus.Observer
+
+	firstMessageOnce sync.Once
+	doneOnce         sync.Once
+}
+
+// RecvMsg observes the first message, and the end of the stream, when an error
+// (including io.EOF) is returned.
+func (s *latencyClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.firstMessageOnce.Do(func() {
+			s.firstMessage.Observe(time.Since(s.start).Seconds())
+		})
+		return nil
+	}
+
+	s.doneOnce.Do(func() {
+		s.total.Observe(time.Since(s.start).Seconds())
+	})
+	return err
+}
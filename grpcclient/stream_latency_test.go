@@ -0,0 +1,73 @@
+package grpcclient_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/grafana/dskit/grpcclient"
+)
+
+// delayedClientStream delays its first message, then streams the remaining ones with
+// a constant delay between them.
+type delayedClientStream struct {
+	grpc.ClientStream
+	firstDelay, delay time.Duration
+	messages          int
+}
+
+func (s *delayedClientStream) RecvMsg(interface{}) error {
+	if s.messages == 0 {
+		return io.EOF
+	}
+	if s.firstDelay > 0 {
+		time.Sleep(s.firstDelay)
+		s.firstDelay = 0
+	} else {
+		time.Sleep(s.delay)
+	}
+	s.messages--
+	return nil
+}
+
+func TestStreamLatencyInstrumentation(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	interceptor := grpcclient.NewStreamLatencyInstrumentation(reg)
+
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &delayedClientStream{firstDelay: 200 * time.Millisecond, delay: 50 * time.Millisecond, messages: 5}, nil
+	}
+	stream, err := interceptor(context.Background(), &grpc.StreamDesc{ServerStreams: true}, &grpc.ClientConn{}, "/test/Stream", streamer)
+	require.NoError(t, err)
+	for {
+		if err := stream.RecvMsg(nil); err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+	}
+	// Further calls once the stream is done aren't observed again.
+	require.Equal(t, io.EOF, stream.RecvMsg(nil))
+
+	metrics, err := reg.Gather()
+	require.NoError(t, err)
+	sums := map[string]float64{}
+	for _, family := range metrics {
+		require.Len(t, family.GetMetric(), 1)
+		m := family.GetMetric()[0]
+		assert.Equal(t, "/test/Stream", m.GetLabel()[0].GetValue())
+		assert.Equal(t, uint64(1), m.GetHistogram().GetSampleCount())
+		sums[family.GetName()] = m.GetHistogram().GetSampleSum()
+	}
+
+	firstMessage := sums["grpc_client_stream_first_message_duration_seconds"]
+	total := sums["grpc_client_stream_duration_seconds"]
+	assert.GreaterOrEqual(t, firstMessage, 0.2)
+	assert.GreaterOrEqual(t, total, 0.4)
+	assert.Less(t, firstMessage, total)
+}
@@ -0,0 +1,38 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// NewStreamMaxLifetime creates a StreamClientInterceptor which cancels a stream once it
+// has been open for maxLifetime. Operations on a canceled stream fail with
+// DeadlineExceeded, forcing the caller to re-establish it.
+func NewStreamMaxLifetime(maxLifetime time.Duration) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, cancel := context.WithTimeout(ctx, maxLifetime)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &maxLifetimeClientStream{ClientStream: stream, cancel: cancel}, nil
+	}
+}
+
+type maxLifetimeClientStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+// RecvMsg releases the stream context as soon as the stream is done (an error,
+// including io.EOF, is returned), instead of waiting for maxLifetime to elapse.
+func (s *maxLifetimeClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package grpcclient
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/alts"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/grafana/dskit/crypto/tls"
+)
+
+// Supported values of Config.CredentialsType.
+const (
+	CredentialsTypeTLS      = "tls"
+	CredentialsTypeInsecure = "insecure"
+	CredentialsTypeALTS     = "alts"
+)
+
+// credentialsType returns the effective credentials type, falling back to the deprecated
+// TLSEnabled when CredentialsType isn't set.
+func (cfg *Config) credentialsType() string {
+	if cfg.CredentialsType != "" {
+		return cfg.CredentialsType
+	}
+	if cfg.TLSEnabled {
+		return CredentialsTypeTLS
+	}
+	return CredentialsTypeInsecure
+}
+
+// tlsConfig returns the TLS config, logging the TLS details with the client Logger
+// unless the TLS config has its own.
+func (cfg *Config) tlsConfig() tls.ClientConfig {
+	tlsCfg := cfg.TLS
+	if tlsCfg.Logger == nil {
+		tlsCfg.Logger = cfg.Logger
+	}
+	return tlsCfg
+}
+
+func (cfg *Config) transportCredentials() (credentials.TransportCredentials, error) {
+	switch t := cfg.credentialsType(); t {
+	case CredentialsTypeTLS:
+		tlsCfg := cfg.tlsConfig()
+		creds, err := tlsCfg.GetGRPCTransportCredentials()
+		if err != nil {
+			return nil, errors.Wrap(err, "error creating grpc dial options")
+		}
+		return creds, nil
+	case CredentialsTypeInsecure:
+		return insecure.NewCredentials(), nil
+	case CredentialsTypeALTS:
+		return alts.NewClientCreds(alts.DefaultClientOptions()), nil
+	default:
+		return nil, fmt.Errorf("unsupported credentials type: %s", t)
+	}
+}
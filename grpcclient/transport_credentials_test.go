@@ -0,0 +1,50 @@
+package grpcclient
+
+import (
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransportCredentials(t *testing.T) {
+	for name, test := range map[string]struct {
+		cfg              Config
+		expectedProtocol string
+	}{
+		"default":                {cfg: Config{}, expectedProtocol: "insecure"},
+		"deprecated TLS enabled": {cfg: Config{TLSEnabled: true}, expectedProtocol: "tls"},
+		"tls":                    {cfg: Config{CredentialsType: CredentialsTypeTLS}, expectedProtocol: "tls"},
+		"tls with TLS enabled":   {cfg: Config{CredentialsType: CredentialsTypeTLS, TLSEnabled: true}, expectedProtocol: "tls"},
+		"insecure":               {cfg: Config{CredentialsType: CredentialsTypeInsecure}, expectedProtocol: "insecure"},
+		"alts":                   {cfg: Config{CredentialsType: CredentialsTypeALTS}, expectedProtocol: "alts"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, test.cfg.Validate(nil))
+			creds, err := test.cfg.transportCredentials()
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedProtocol, creds.Info().SecurityProtocol)
+		})
+	}
+}
+
+func TestConfigValidateCredentialsType(t *testing.T) {
+	cfg := Config{CredentialsType: "kerberos"}
+	assert.EqualError(t, cfg.Validate(nil), "unsupported credentials type: kerberos")
+
+	cfg = Config{CredentialsType: CredentialsTypeALTS, TLSEnabled: true}
+	assert.EqualError(t, cfg.Validate(nil), "TLS can't be enabled with the alts credentials type")
+}
+
+func TestConfigValidateLogTLSDetails(t *testing.T) {
+	cfg := Config{CredentialsType: CredentialsTypeTLS}
+	cfg.TLS.LogTLSDetails = true
+	require.Error(t, cfg.Validate(nil))
+
+	// The TLS details are logged with the client logger.
+	cfg.Logger = log.NewNopLogger()
+	require.NoError(t, cfg.Validate(nil))
+	_, err := cfg.transportCredentials()
+	require.NoError(t, err)
+}
@@ -0,0 +1,109 @@
+package checksum
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/grafana/dskit/grpcencoding/snappy"
+)
+
+// SnappyName is the name registered for the snappy compressor with checksum verification.
+const SnappyName = snappy.Name + nameSuffix
+
+const (
+	nameSuffix   = "-crc"
+	checksumSize = crc32.Size
+)
+
+// ErrChecksumMismatch is returned when decompressing a message whose checksum doesn't match.
+var ErrChecksumMismatch = errors.New("checksum mismatch: the message has been corrupted")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func init() {
+	Register()
+}
+
+// Register registers the snappy compressor with checksum verification with gRPC.
+func Register() {
+	encoding.RegisterCompressor(Wrap(encoding.GetCompressor(snappy.Name)))
+}
+
+// Wrap returns a compressor which appends a CRC32C of the compressed message produced by
+// c, and verifies it when decompressing. The returned compressor is named after c, with
+// a "-crc" suffix.
+func Wrap(c encoding.Compressor) encoding.Compressor {
+	return &compressor{inner: c}
+}
+
+type compressor struct {
+	inner encoding.Compressor
+}
+
+func (c *compressor) Name() string {
+	return c.inner.Name() + nameSuffix
+}
+
+func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	hw := &hashingWriter{writer: w, hash: crc32.New(crc32cTable)}
+	iw, err := c.inner.Compress(hw)
+	if err != nil {
+		return nil, err
+	}
+	return &writeCloser{inner: iw, hashing: hw}, nil
+}
+
+func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < checksumSize {
+		return nil, errors.Wrap(ErrChecksumMismatch, "message too short to contain a checksum")
+	}
+
+	payload, expected := data[:len(data)-checksumSize], binary.BigEndian.Uint32(data[len(data)-checksumSize:])
+	if actual := crc32.Checksum(payload, crc32cTable); actual != expected {
+		return nil, errors.Wrapf(ErrChecksumMismatch, "expected %08x, got %08x", expected, actual)
+	}
+	return c.inner.Decompress(bytes.NewReader(payload))
+}
+
+// hashingWriter computes the checksum of everything written through it.
+type hashingWriter struct {
+	writer io.Writer
+	hash   hash.Hash32
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.hash.Write(p[:n])
+	return n, err
+}
+
+type writeCloser struct {
+	inner   io.WriteCloser
+	hashing *hashingWriter
+}
+
+func (w *writeCloser) Write(p []byte) (int, error) {
+	return w.inner.Write(p)
+}
+
+// Close flushes the compressed message and appends its checksum.
+func (w *writeCloser) Close() error {
+	if err := w.inner.Close(); err != nil {
+		return err
+	}
+
+	checksum := make([]byte, checksumSize)
+	binary.BigEndian.PutUint32(checksum, w.hashing.hash.Sum32())
+	_, err := w.hashing.writer.Write(checksum)
+	return err
+}
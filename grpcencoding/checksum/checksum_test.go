@@ -0,0 +1,66 @@
+package checksum
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestSnappyWithChecksum(t *testing.T) {
+	c := encoding.GetCompressor(SnappyName)
+	require.NotNil(t, c)
+	assert.Equal(t, "snappy-crc", c.Name())
+
+	tests := []struct {
+		test  string
+		input string
+	}{
+		{"empty", ""},
+		{"short", "hello world"},
+		{"long", strings.Repeat("123456789", 1024)},
+	}
+	for _, test := range tests {
+		t.Run(test.test, func(t *testing.T) {
+			compressed := compress(t, c, test.input)
+
+			// Intact message.
+			r, err := c.Decompress(bytes.NewReader(compressed))
+			require.NoError(t, err)
+			out, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, test.input, string(out))
+
+			// Corrupted message.
+			corrupted := append([]byte(nil), compressed...)
+			corrupted[len(corrupted)/2] ^= 0xff
+			_, err = c.Decompress(bytes.NewReader(corrupted))
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrChecksumMismatch))
+		})
+	}
+}
+
+func TestSnappyWithChecksum_TruncatedMessage(t *testing.T) {
+	c := encoding.GetCompressor(SnappyName)
+
+	_, err := c.Decompress(bytes.NewReader([]byte{0x01, 0x02}))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrChecksumMismatch))
+}
+
+func compress(t *testing.T, c encoding.Compressor, input string) []byte {
+	var buf bytes.Buffer
+	w, err := c.Compress(&buf)
+	require.NoError(t, err)
+	n, err := w.Write([]byte(input))
+	require.NoError(t, err)
+	assert.Len(t, input, n)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
@@ -0,0 +1,23 @@
+// Package grpcencoding provides access to all the gRPC compressors shipped with dskit.
+//
+// dskit doesn't ship a zstd compressor, since no zstd implementation is a dependency
+// of this module, so zstd specific options such as compression dictionaries or
+// long-distance matching aren't available.
+package grpcencoding
+
+import (
+	"github.com/grafana/dskit/grpcencoding/checksum"
+	"github.com/grafana/dskit/grpcencoding/snappy"
+)
+
+// Names returns the names of all the dskit-provided compressors.
+func Names() []string {
+	return []string{snappy.Name, checksum.SnappyName}
+}
+
+// RegisterAll registers all the dskit-provided compressors with gRPC, so that they can
+// be selected by name without importing each compressor package for its side effects.
+func RegisterAll() {
+	snappy.Register()
+	checksum.Register()
+}
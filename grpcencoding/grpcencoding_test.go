@@ -0,0 +1,20 @@
+package grpcencoding
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/encoding"
+)
+
+func TestRegisterAll(t *testing.T) {
+	RegisterAll()
+
+	assert.ElementsMatch(t, []string{"snappy", "snappy-crc"}, Names())
+	for _, name := range Names() {
+		c := encoding.GetCompressor(name)
+		require.NotNil(t, c, name)
+		assert.Equal(t, name, c.Name())
+	}
+}
@@ -1,6 +1,7 @@
 package snappy
 
 import (
+	"fmt"
 	"io"
 	"sync"
 
@@ -11,17 +12,46 @@ import (
 // Name is the name registered for the snappy compressor.
 const Name = "snappy"
 
+const (
+	// MaxFrameSize is the largest amount of uncompressed data in a frame (chunk) of the
+	// snappy framing format, and the default frame size.
+	MaxFrameSize = 64 << 10
+
+	// MinFrameSize is the smallest frame size accepted by RegisterWithMaxFrameSize.
+	MinFrameSize = 1
+)
+
 func init() {
-	encoding.RegisterCompressor(newCompressor())
+	Register()
+}
+
+// Register registers the snappy compressor with gRPC, with frames of up to MaxFrameSize.
+func Register() {
+	encoding.RegisterCompressor(newCompressor(MaxFrameSize))
+}
+
+// RegisterWithMaxFrameSize registers the snappy compressor with gRPC, compressing messages
+// in frames of up to maxFrameSize bytes of uncompressed data, which must be between
+// MinFrameSize and MaxFrameSize. Smaller frames reduce the amount of data compressed at
+// once, at the cost of a lower compression ratio. The snappy-crc
+// compressor wraps the snappy compressor registered when its Register function is
+// called, so it must be registered again to use the new frame size.
+func RegisterWithMaxFrameSize(maxFrameSize int) error {
+	if maxFrameSize < MinFrameSize || maxFrameSize > MaxFrameSize {
+		return fmt.Errorf("invalid snappy max frame size %d: it must be between %d and %d", maxFrameSize, MinFrameSize, MaxFrameSize)
+	}
+	encoding.RegisterCompressor(newCompressor(maxFrameSize))
+	return nil
 }
 
 type compressor struct {
-	writersPool sync.Pool
-	readersPool sync.Pool
+	maxFrameSize int
+	writersPool  sync.Pool
+	readersPool  sync.Pool
 }
 
-func newCompressor() *compressor {
-	c := &compressor{}
+func newCompressor(maxFrameSize int) *compressor {
+	c := &compressor{maxFrameSize: maxFrameSize}
 	c.readersPool = sync.Pool{
 		New: func() interface{} {
 			return snappy.NewReader(nil)
@@ -39,16 +69,24 @@ func (c *compressor) Name() string {
 	return Name
 }
 
+// MaxFrameSize returns the largest amount of uncompressed data in each frame.
+func (c *compressor) MaxFrameSize() int {
+	return c.maxFrameSize
+}
+
 func (c *compressor) Compress(w io.Writer) (io.WriteCloser, error) {
 	wr := c.writersPool.Get().(*snappy.Writer)
 	wr.Reset(w)
+	if c.maxFrameSize < MaxFrameSize {
+		return &framingWriteCloser{writeCloser: writeCloser{wr, &c.writersPool}, maxFrameSize: c.maxFrameSize}, nil
+	}
 	return writeCloser{wr, &c.writersPool}, nil
 }
 
 func (c *compressor) Decompress(r io.Reader) (io.Reader, error) {
 	dr := c.readersPool.Get().(*snappy.Reader)
 	dr.Reset(r)
-	return reader{dr, &c.readersPool}, nil
+	return &reader{dr, &c.readersPool}, nil
 }
 
 type writeCloser struct {
@@ -72,16 +110,55 @@ func (w writeCloser) Close() error {
 	return nil
 }
 
+// framingWriteCloser flushes a frame every maxFrameSize bytes, while the snappy writer
+// only does it every MaxFrameSize bytes.
+type framingWriteCloser struct {
+	writeCloser
+	maxFrameSize int
+	buffered     int
+}
+
+func (w *framingWriteCloser) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		size := w.maxFrameSize - w.buffered
+		if size > len(p) {
+			size = len(p)
+		}
+		written, err := w.writer.Write(p[:size])
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[size:]
+
+		w.buffered += size
+		if w.buffered == w.maxFrameSize {
+			if err := w.writer.Flush(); err != nil {
+				return n, err
+			}
+			w.buffered = 0
+		}
+	}
+	return n, nil
+}
+
+// reader returns the snappy reader to the pool once it has read the whole message, and
+// keeps returning io.EOF afterwards: an empty message reaches io.EOF on the first read,
+// and reading again mustn't use the reader once it's back in the pool.
 type reader struct {
 	reader *snappy.Reader
 	pool   *sync.Pool
 }
 
-func (r reader) Read(p []byte) (n int, err error) {
+func (r *reader) Read(p []byte) (n int, err error) {
+	if r.reader == nil {
+		return 0, io.EOF
+	}
 	n, err = r.reader.Read(p)
 	if err == io.EOF {
 		r.reader.Reset(nil)
 		r.pool.Put(r.reader)
+		r.reader = nil
 	}
 	return n, err
 }
@@ -2,16 +2,18 @@ package snappy
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/encoding"
 )
 
 func TestSnappy(t *testing.T) {
-	c := newCompressor()
+	c := newCompressor(MaxFrameSize)
 	assert.Equal(t, "snappy", c.Name())
 
 	tests := []struct {
@@ -19,6 +21,7 @@ func TestSnappy(t *testing.T) {
 		input string
 	}{
 		{"empty", ""},
+		{"single byte", "x"},
 		{"short", "hello world"},
 		{"long", strings.Repeat("123456789", 1024)},
 	}
@@ -43,9 +46,59 @@ func TestSnappy(t *testing.T) {
 	}
 }
 
+func TestSnappyEmptyAndSingleByteMessages(t *testing.T) {
+	for _, maxFrameSize := range []int{MinFrameSize, MaxFrameSize} {
+		for _, input := range [][]byte{nil, {}, {'x'}} {
+			t.Run(fmt.Sprintf("frame size %d, %d bytes", maxFrameSize, len(input)), func(t *testing.T) {
+				c := newCompressor(maxFrameSize)
+
+				var buf bytes.Buffer
+				w, err := c.Compress(&buf)
+				require.NoError(t, err)
+				n, err := w.Write(input)
+				require.NoError(t, err)
+				assert.Equal(t, len(input), n)
+				require.NoError(t, w.Close())
+				if len(input) == 0 {
+					assert.Zero(t, buf.Len(), "an empty message should compress to an empty payload")
+				}
+
+				r, err := c.Decompress(&buf)
+				require.NoError(t, err)
+				out, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, len(input), len(out))
+				assert.Equal(t, string(input), string(out))
+
+				// Reading again once the message has been read keeps returning io.EOF.
+				for i := 0; i < 2; i++ {
+					n, err := r.Read(make([]byte, 1))
+					assert.Zero(t, n)
+					assert.Equal(t, io.EOF, err)
+				}
+
+				// The pooled reader isn't shared with the next message.
+				var other bytes.Buffer
+				w, err = c.Compress(&other)
+				require.NoError(t, err)
+				_, err = w.Write([]byte("hello world"))
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				next, err := c.Decompress(&other)
+				require.NoError(t, err)
+				_, err = r.Read(make([]byte, 1))
+				assert.Equal(t, io.EOF, err)
+				out, err = io.ReadAll(next)
+				require.NoError(t, err)
+				assert.Equal(t, "hello world", string(out))
+			})
+		}
+	}
+}
+
 func BenchmarkSnappyCompress(b *testing.B) {
 	data := []byte(strings.Repeat("123456789", 1024))
-	c := newCompressor()
+	c := newCompressor(MaxFrameSize)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		w, _ := c.Compress(io.Discard)
@@ -56,7 +109,7 @@ func BenchmarkSnappyCompress(b *testing.B) {
 
 func BenchmarkSnappyDecompress(b *testing.B) {
 	data := []byte(strings.Repeat("123456789", 1024))
-	c := newCompressor()
+	c := newCompressor(MaxFrameSize)
 	var buf bytes.Buffer
 	w, _ := c.Compress(&buf)
 	_, _ = w.Write(data)
@@ -68,3 +121,78 @@ func BenchmarkSnappyDecompress(b *testing.B) {
 		_, _ = reader.Seek(0, io.SeekStart)
 	}
 }
+
+func TestSnappyMaxFrameSize(t *testing.T) {
+	input := []byte(strings.Repeat("123456789", 50*1024))
+
+	for _, maxFrameSize := range []int{MinFrameSize, 1000, 4096, 16 << 10, MaxFrameSize} {
+		t.Run(fmt.Sprintf("%d", maxFrameSize), func(t *testing.T) {
+			c := newCompressor(maxFrameSize)
+			assert.Equal(t, maxFrameSize, c.MaxFrameSize())
+
+			var buf bytes.Buffer
+			w, err := c.Compress(&buf)
+			require.NoError(t, err)
+			// Write in pieces not aligned with the frames.
+			for data := input; len(data) > 0; {
+				size := 3001
+				if size > len(data) {
+					size = len(data)
+				}
+				n, err := w.Write(data[:size])
+				require.NoError(t, err)
+				require.Equal(t, size, n)
+				data = data[size:]
+			}
+			require.NoError(t, w.Close())
+
+			// Each frame is preceded by a 4 bytes header: skip the stream identifier and
+			// check the frame lengths.
+			frames := buf.Bytes()[10:]
+			numFrames := 0
+			for len(frames) > 0 {
+				length := int(frames[1]) | int(frames[2])<<8 | int(frames[3])<<16
+				// A frame holds up to maxFrameSize bytes of data, stored uncompressed if
+				// compression doesn't help, and a 4 bytes checksum.
+				assert.LessOrEqual(t, length, maxFrameSize+4)
+				frames = frames[4+length:]
+				numFrames++
+			}
+			assert.Equal(t, (len(input)+maxFrameSize-1)/maxFrameSize, numFrames)
+
+			r, err := c.Decompress(&buf)
+			require.NoError(t, err)
+			out, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, input, out)
+		})
+	}
+}
+
+func TestRegisterWithMaxFrameSize(t *testing.T) {
+	defer Register()
+
+	require.NoError(t, RegisterWithMaxFrameSize(4096))
+	assert.Equal(t, 4096, encoding.GetCompressor(Name).(*compressor).MaxFrameSize())
+
+	for _, invalid := range []int{-1, 0, MaxFrameSize + 1} {
+		assert.EqualError(t, RegisterWithMaxFrameSize(invalid), fmt.Sprintf("invalid snappy max frame size %d: it must be between 1 and 65536", invalid))
+	}
+	assert.Equal(t, 4096, encoding.GetCompressor(Name).(*compressor).MaxFrameSize())
+}
+
+func BenchmarkSnappyCompressMaxFrameSize(b *testing.B) {
+	data := []byte(strings.Repeat("123456789", 1024*1024))
+	for _, maxFrameSize := range []int{4 << 10, 16 << 10, MaxFrameSize} {
+		b.Run(fmt.Sprintf("%d", maxFrameSize), func(b *testing.B) {
+			c := newCompressor(maxFrameSize)
+			b.SetBytes(int64(len(data)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				w, _ := c.Compress(io.Discard)
+				_, _ = w.Write(data)
+				_ = w.Close()
+			}
+		})
+	}
+}
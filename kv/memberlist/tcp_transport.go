@@ -127,7 +127,11 @@ func NewTCPTransport(config TCPTransportConfig, logger log.Logger) (*TCPTranspor
 
 	var err error
 	if config.TLSEnabled {
-		t.tlsConfig, err = config.TLS.GetTLSConfig()
+		tlsCfg := config.TLS
+		if tlsCfg.Logger == nil {
+			tlsCfg.Logger = t.logger
+		}
+		t.tlsConfig, err = tlsCfg.GetTLSConfig()
 		if err != nil {
 			return nil, errors.Wrap(err, "unable to create TLS config")
 		}
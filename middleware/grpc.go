@@ -9,21 +9,26 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	grpcUtils "github.com/weaveworks/common/grpc"
 	"github.com/weaveworks/common/httpgrpc"
+	"github.com/weaveworks/common/tracing"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 )
 
 // PrometheusGRPCUnaryInstrumentation records duration of gRPC requests client side.
+// When the request is part of a sampled trace, its trace ID is attached to the observed
+// duration as an exemplar.
 func PrometheusGRPCUnaryInstrumentation(metric *prometheus.HistogramVec) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req, resp interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
 		start := time.Now()
 		err := invoker(ctx, method, req, resp, cc, opts...)
-		metric.WithLabelValues(method, errorCode(err)).Observe(time.Since(start).Seconds())
+		observeWithExemplar(ctx, metric.WithLabelValues(method, errorCode(err)), time.Since(start).Seconds())
 		return err
 	}
 }
 
 // PrometheusGRPCStreamInstrumentation records duration of streaming gRPC requests client side.
+// When the stream is part of a sampled trace, its trace ID is attached to the observed
+// duration as an exemplar.
 func PrometheusGRPCStreamInstrumentation(metric *prometheus.HistogramVec) grpc.StreamClientInterceptor {
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
 		streamer grpc.Streamer, opts ...grpc.CallOption,
@@ -31,6 +36,7 @@ func PrometheusGRPCStreamInstrumentation(metric *prometheus.HistogramVec) grpc.S
 		start := time.Now()
 		stream, err := streamer(ctx, desc, cc, method, opts...)
 		return &instrumentedClientStream{
+			ctx:          ctx,
 			metric:       metric,
 			start:        start,
 			method:       method,
@@ -40,6 +46,7 @@ func PrometheusGRPCStreamInstrumentation(metric *prometheus.HistogramVec) grpc.S
 }
 
 type instrumentedClientStream struct {
+	ctx    context.Context
 	metric *prometheus.HistogramVec
 	start  time.Time
 	method string
@@ -53,9 +60,9 @@ func (s *instrumentedClientStream) SendMsg(m interface{}) error {
 	}
 
 	if err == io.EOF {
-		s.metric.WithLabelValues(s.method, errorCode(nil)).Observe(time.Since(s.start).Seconds())
+		observeWithExemplar(s.ctx, s.metric.WithLabelValues(s.method, errorCode(nil)), time.Since(s.start).Seconds())
 	} else {
-		s.metric.WithLabelValues(s.method, errorCode(err)).Observe(time.Since(s.start).Seconds())
+		observeWithExemplar(s.ctx, s.metric.WithLabelValues(s.method, errorCode(err)), time.Since(s.start).Seconds())
 	}
 
 	return err
@@ -68,9 +75,9 @@ func (s *instrumentedClientStream) RecvMsg(m interface{}) error {
 	}
 
 	if err == io.EOF {
-		s.metric.WithLabelValues(s.method, errorCode(nil)).Observe(time.Since(s.start).Seconds())
+		observeWithExemplar(s.ctx, s.metric.WithLabelValues(s.method, errorCode(nil)), time.Since(s.start).Seconds())
 	} else {
-		s.metric.WithLabelValues(s.method, errorCode(err)).Observe(time.Since(s.start).Seconds())
+		observeWithExemplar(s.ctx, s.metric.WithLabelValues(s.method, errorCode(err)), time.Since(s.start).Seconds())
 	}
 
 	return err
@@ -79,11 +86,26 @@ func (s *instrumentedClientStream) RecvMsg(m interface{}) error {
 func (s *instrumentedClientStream) Header() (metadata.MD, error) {
 	md, err := s.ClientStream.Header()
 	if err != nil {
-		s.metric.WithLabelValues(s.method, errorCode(err)).Observe(time.Since(s.start).Seconds())
+		observeWithExemplar(s.ctx, s.metric.WithLabelValues(s.method, errorCode(err)), time.Since(s.start).Seconds())
 	}
 	return md, err
 }
 
+// extractSampledTraceID returns the trace ID of ctx, if it carries a sampled trace.
+var extractSampledTraceID = tracing.ExtractSampledTraceID
+
+// observeWithExemplar observes value, with the trace ID of ctx as an exemplar if ctx
+// carries a sampled trace.
+func observeWithExemplar(ctx context.Context, observer prometheus.Observer, value float64) {
+	if traceID, ok := extractSampledTraceID(ctx); ok {
+		if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok {
+			exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{"traceID": traceID})
+			return
+		}
+	}
+	observer.Observe(value)
+}
+
 func errorCode(err error) string {
 	respStatus := "2xx"
 	if err != nil {
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// NewCancellationMapper returns a gRPC client interceptor which marks errors caused by
+// the caller's own context being done (canceled or past its deadline). The returned
+// error keeps the original gRPC status, so the status code seen by callers is unchanged,
+// but interceptors placed before it in the chain can use IsClientCanceled to tell a
+// client-initiated cancellation apart from a server-side or genuine failure.
+func NewCancellationMapper() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err != nil && ctx.Err() != nil {
+			return clientCanceledError{err: err}
+		}
+		return err
+	}
+}
+
+// IsClientCanceled returns true if err was marked by NewCancellationMapper as caused
+// by the caller's context.
+func IsClientCanceled(err error) bool {
+	var target clientCanceledError
+	return errors.As(err, &target)
+}
+
+type clientCanceledError struct {
+	err error
+}
+
+func (e clientCanceledError) Error() string {
+	return e.err.Error()
+}
+
+func (e clientCanceledError) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus preserves the status of the wrapped error, translating plain context
+// errors into their corresponding gRPC codes.
+func (e clientCanceledError) GRPCStatus() *status.Status {
+	if s, ok := status.FromError(e.err); ok {
+		return s
+	}
+	return status.FromContextError(e.err)
+}
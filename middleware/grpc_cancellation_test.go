@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCancellationMapper(t *testing.T) {
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := map[string]struct {
+		ctx              context.Context
+		err              error
+		expectedCode     codes.Code
+		expectedCanceled bool
+	}{
+		"caller canceled": {
+			ctx:              canceledCtx,
+			err:              status.Error(codes.Canceled, context.Canceled.Error()),
+			expectedCode:     codes.Canceled,
+			expectedCanceled: true,
+		},
+		"caller canceled with plain context error": {
+			ctx:              canceledCtx,
+			err:              context.Canceled,
+			expectedCode:     codes.Canceled,
+			expectedCanceled: true,
+		},
+		"server canceled": {
+			ctx:              context.Background(),
+			err:              status.Error(codes.Canceled, "canceled by server"),
+			expectedCode:     codes.Canceled,
+			expectedCanceled: false,
+		},
+		"genuine error": {
+			ctx:              context.Background(),
+			err:              status.Error(codes.Internal, "boom"),
+			expectedCode:     codes.Internal,
+			expectedCanceled: false,
+		},
+		"success": {
+			ctx:              canceledCtx,
+			err:              nil,
+			expectedCode:     codes.OK,
+			expectedCanceled: false,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				return testData.err
+			}
+
+			err := NewCancellationMapper()(testData.ctx, "method", nil, nil, nil, invoker)
+			assert.Equal(t, testData.expectedCode, status.Code(err))
+			assert.Equal(t, testData.expectedCanceled, IsClientCanceled(err))
+			if testData.err != nil {
+				assert.True(t, errors.Is(err, testData.err))
+			}
+		})
+	}
+}
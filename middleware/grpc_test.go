@@ -1,11 +1,20 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
+	"strconv"
 	"testing"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/weaveworks/common/httpgrpc"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -38,3 +47,55 @@ func TestErrorCode_Unknown(t *testing.T) {
 	a := errorCode(err)
 	assert.Equal(t, a, "error")
 }
+
+func TestPrometheusGRPCUnaryInstrumentationAttachesTraceIDExemplar(t *testing.T) {
+	// The trace IDs are extracted from the mock spans instead of the Jaeger ones.
+	defer func(extract func(context.Context) (string, bool)) {
+		extractSampledTraceID = extract
+	}(extractSampledTraceID)
+	extractSampledTraceID = func(ctx context.Context) (string, bool) {
+		span := opentracing.SpanFromContext(ctx)
+		if span == nil {
+			return "", false
+		}
+		spanCtx := span.Context().(mocktracer.MockSpanContext)
+		return strconv.Itoa(spanCtx.TraceID), spanCtx.Sampled
+	}
+	tracer := mocktracer.New()
+
+	reg := prometheus.NewPedanticRegistry()
+	metric := promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "request_duration_seconds",
+		Help:    "Time spent doing requests.",
+		Buckets: []float64{1},
+	}, []string{"operation", "status_code"})
+	interceptor := PrometheusGRPCUnaryInstrumentation(metric)
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+
+	span := tracer.StartSpan("test")
+	defer span.Finish()
+	spanCtx := opentracing.ContextWithSpan(context.Background(), span)
+	require.NoError(t, interceptor(spanCtx, "/test/Traced", nil, nil, nil, invoker))
+	require.NoError(t, interceptor(context.Background(), "/test/Untraced", nil, nil, nil, invoker))
+
+	exemplars := map[string]*dto.Exemplar{}
+	families, err := reg.Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 1)
+	for _, m := range families[0].GetMetric() {
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "operation" {
+				exemplars[label.GetValue()] = m.GetHistogram().GetBucket()[0].GetExemplar()
+			}
+		}
+	}
+
+	require.NotNil(t, exemplars["/test/Traced"])
+	labels := exemplars["/test/Traced"].GetLabel()
+	require.Len(t, labels, 1)
+	assert.Equal(t, "traceID", labels[0].GetName())
+	assert.Equal(t, strconv.Itoa(span.Context().(mocktracer.MockSpanContext).TraceID), labels[0].GetValue())
+	assert.Nil(t, exemplars["/test/Untraced"])
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// inFlightCalls tracks the number of in-flight calls by connection target.
+type inFlightCalls struct {
+	mu      sync.Mutex
+	calls   map[string]int
+	changed chan struct{} // Closed, and replaced, whenever a call completes.
+}
+
+func newInFlightCalls() *inFlightCalls {
+	return &inFlightCalls{
+		calls:   map[string]int{},
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *inFlightCalls) inc(target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[target]++
+}
+
+func (c *inFlightCalls) dec(target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls[target]--
+	if c.calls[target] <= 0 {
+		delete(c.calls, target)
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// count returns the number of in-flight calls to target.
+func (c *inFlightCalls) count(target string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[target]
+}
+
+// wait until there are no in-flight calls to target, or ctx is done.
+func (c *inFlightCalls) wait(ctx context.Context, target string) error {
+	for {
+		c.mu.Lock()
+		calls, changed := c.calls[target], c.changed
+		c.mu.Unlock()
+
+		if calls == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// UnaryClientInterceptor returns an interceptor tracking the in-flight calls of the
+// pool clients, so that Shutdown can wait for them to complete. It must be installed on
+// the connections created by the pool factory, dialing the address given to it.
+func (p *Pool) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		target := cc.Target()
+		p.inFlight.inc(target)
+		defer p.inFlight.dec(target)
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor is the StreamClientInterceptor counterpart of
+// UnaryClientInterceptor. A stream is in flight until it's been fully consumed, or
+// canceled.
+func (p *Pool) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		target := cc.Target()
+		p.inFlight.inc(target)
+
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			p.inFlight.dec(target)
+			return nil, err
+		}
+		s := &inFlightClientStream{ClientStream: stream}
+		s.done = func() { p.inFlight.dec(target) }
+		// The stream context is done once the stream completes, also when the caller
+		// cancels it rather than consuming it.
+		go func() {
+			<-stream.Context().Done()
+			s.once.Do(s.done)
+		}()
+		return s, nil
+	}
+}
+
+// inFlightClientStream calls done once the stream completes, that is when RecvMsg
+// returns an error (including io.EOF) or the stream context is done.
+type inFlightClientStream struct {
+	grpc.ClientStream
+	once sync.Once
+	done func()
+}
+
+func (s *inFlightClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.once.Do(s.done)
+	}
+	return err
+}
@@ -2,8 +2,10 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
@@ -11,6 +13,7 @@ import (
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/weaveworks/common/user"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/grafana/dskit/ring/util"
@@ -38,8 +41,41 @@ type PoolConfig struct {
 	CheckInterval      time.Duration
 	HealthCheckEnabled bool
 	HealthCheckTimeout time.Duration
+
+	// MaxConcurrentDials is the maximum number of clients created concurrently, across
+	// all addresses. Concurrent requests for the same address always share a single
+	// client creation. 0 means clients are created one at a time.
+	MaxConcurrentDials int
+
+	// MaxConnections is the maximum number of clients in the pool. When a new client
+	// exceeds it, the least recently used client is evicted, and closed once its
+	// in-flight calls have completed. 0 means unlimited.
+	MaxConnections int
+
+	// WarmupInterval, if set, is how often the clients without activity are warmed up
+	// with a health check, so that their connection stays warm and is known to be alive
+	// when it's used again. Clients used within the interval, or with calls in flight,
+	// are skipped. Clients failing the health check are removed. The health check times
+	// out after HealthCheckTimeout, or defaultWarmupTimeout if it isn't set, as it often
+	// isn't with HealthCheckEnabled false. 0 means disabled.
+	WarmupInterval time.Duration
 }
 
+// defaultWarmupTimeout is the timeout of the warmup health checks when
+// PoolConfig.HealthCheckTimeout isn't set.
+const defaultWarmupTimeout = time.Second
+
+// evictedClientDrainTimeout is how long an evicted client waits for its in-flight calls
+// to complete before being closed.
+const evictedClientDrainTimeout = 30 * time.Second
+
+// ErrPoolShutdown is returned when getting a client from a pool which has been shut down.
+var ErrPoolShutdown = errors.New("client pool is shut down")
+
+// ErrClientDraining is returned when getting the client for an address being drained,
+// see Pool.Drain.
+var ErrClientDraining = errors.New("client is draining")
+
 // Pool holds a cache of grpc_health_v1 clients.
 type Pool struct {
 	services.Service
@@ -51,29 +87,81 @@ type Pool struct {
 	clientName string
 
 	sync.RWMutex
-	clients map[string]PoolClient
+	clients  map[string]PoolClient
+	lastUsed map[string]*atomic.Int64 // Unix nanoseconds of the last GetClientFor, by address.
+	dials    map[string]*poolDial     // Clients being created, by address.
+	draining map[string]struct{}      // Addresses whose client is being drained.
+	shutdown bool
+
+	inFlight *inFlightCalls
+
+	// Bounds the number of clients created concurrently.
+	dialsSemaphore chan struct{}
 
 	clientsMetric prometheus.Gauge
 }
 
+// poolDial tracks the creation of a client, so that concurrent requests for the
+// same address can wait for it instead of creating their own client.
+type poolDial struct {
+	done   chan struct{}
+	client PoolClient
+	err    error
+}
+
 // NewPool creates a new Pool.
 func NewPool(clientName string, cfg PoolConfig, discovery PoolServiceDiscovery, factory PoolFactory, clientsMetric prometheus.Gauge, logger log.Logger) *Pool {
+	maxConcurrentDials := cfg.MaxConcurrentDials
+	if maxConcurrentDials <= 0 {
+		maxConcurrentDials = 1
+	}
+
 	p := &Pool{
-		cfg:           cfg,
-		discovery:     discovery,
-		factory:       factory,
-		logger:        logger,
-		clientName:    clientName,
-		clients:       map[string]PoolClient{},
-		clientsMetric: clientsMetric,
+		cfg:            cfg,
+		discovery:      discovery,
+		factory:        factory,
+		logger:         logger,
+		clientName:     clientName,
+		clients:        map[string]PoolClient{},
+		lastUsed:       map[string]*atomic.Int64{},
+		dials:          map[string]*poolDial{},
+		draining:       map[string]struct{}{},
+		inFlight:       newInFlightCalls(),
+		dialsSemaphore: make(chan struct{}, maxConcurrentDials),
+		clientsMetric:  clientsMetric,
 	}
 
 	p.Service = services.
-		NewTimerService(cfg.CheckInterval, nil, p.iteration, nil).
+		NewBasicService(nil, p.running, nil).
 		WithName(fmt.Sprintf("%s client pool", p.clientName))
 	return p
 }
 
+func (p *Pool) running(ctx context.Context) error {
+	checkTicker := time.NewTicker(p.cfg.CheckInterval)
+	defer checkTicker.Stop()
+
+	var warmup <-chan time.Time
+	if p.cfg.WarmupInterval > 0 {
+		warmupTicker := time.NewTicker(p.cfg.WarmupInterval)
+		defer warmupTicker.Stop()
+		warmup = warmupTicker.C
+	}
+
+	for {
+		select {
+		case <-checkTicker.C:
+			if err := p.iteration(ctx); err != nil {
+				return err
+			}
+		case <-warmup:
+			p.warmupIdleClients()
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
 func (p *Pool) iteration(ctx context.Context) error {
 	p.removeStaleClients()
 	if p.cfg.HealthCheckEnabled {
@@ -92,27 +180,88 @@ func (p *Pool) fromCache(addr string) (PoolClient, bool) {
 // GetClientFor gets the client for the specified address. If it does not exist it will make a new client
 // at that address
 func (p *Pool) GetClientFor(addr string) (PoolClient, error) {
-	client, ok := p.fromCache(addr)
+	p.RLock()
+	client, ok := p.clients[addr]
+	shutdown := p.shutdown
+	if ok && !shutdown {
+		p.touch(addr)
+	}
+	p.RUnlock()
+	if shutdown {
+		return nil, ErrPoolShutdown
+	}
 	if ok {
 		return client, nil
 	}
 
 	p.Lock()
-	defer p.Unlock()
+	if p.shutdown {
+		p.Unlock()
+		return nil, ErrPoolShutdown
+	}
+	if _, draining := p.draining[addr]; draining {
+		p.Unlock()
+		return nil, ErrClientDraining
+	}
 	client, ok = p.clients[addr]
 	if ok {
+		p.touch(addr)
+		p.Unlock()
 		return client, nil
 	}
 
-	client, err := p.factory(addr)
-	if err != nil {
-		return nil, err
+	// Wait for the client if it's already being created by someone else.
+	dial, inFlight := p.dials[addr]
+	if !inFlight {
+		dial = &poolDial{done: make(chan struct{})}
+		p.dials[addr] = dial
 	}
-	p.clients[addr] = client
-	if p.clientsMetric != nil {
-		p.clientsMetric.Add(1)
+	p.Unlock()
+
+	if inFlight {
+		<-dial.done
+		return dial.client, dial.err
+	}
+
+	// Create the client without holding the lock, so that a slow dial doesn't block
+	// requests for clients which are already cached.
+	p.dialsSemaphore <- struct{}{}
+	dial.client, dial.err = p.factory(addr)
+	<-p.dialsSemaphore
+
+	var evicted map[string]PoolClient
+	p.Lock()
+	delete(p.dials, addr)
+	shutdown = p.shutdown
+	_, draining := p.draining[addr]
+	if dial.err == nil && !shutdown && !draining {
+		p.clients[addr] = dial.client
+		p.lastUsed[addr] = atomic.NewInt64(0)
+		p.touch(addr)
+		if p.clientsMetric != nil {
+			p.clientsMetric.Add(1)
+		}
+		evicted = p.evictLocked()
+	}
+	p.Unlock()
+
+	for evictedAddr, evictedClient := range evicted {
+		level.Info(p.logger).Log("msg", fmt.Sprintf("evicting least recently used %s", p.clientName), "addr", evictedAddr)
+		go p.closeClientWhenIdle(evictedAddr, evictedClient)
 	}
-	return client, nil
+
+	if dial.err == nil && shutdown {
+		// The pool has been shut down while creating the client.
+		p.closeClient(addr, dial.client)
+		dial.client, dial.err = nil, ErrPoolShutdown
+	} else if dial.err == nil && draining {
+		// The address has been drained while creating the client.
+		p.closeClient(addr, dial.client)
+		dial.client, dial.err = nil, ErrClientDraining
+	}
+	close(dial.done)
+
+	return dial.client, dial.err
 }
 
 // RemoveClientFor removes the client with the specified address
@@ -122,16 +271,138 @@ func (p *Pool) RemoveClientFor(addr string) {
 	client, ok := p.clients[addr]
 	if ok {
 		delete(p.clients, addr)
+		delete(p.lastUsed, addr)
 		if p.clientsMetric != nil {
 			p.clientsMetric.Add(-1)
 		}
 		// Close in the background since this operation may take awhile and we have a mutex
-		go func(addr string, closer PoolClient) {
-			if err := closer.Close(); err != nil {
-				level.Error(p.logger).Log("msg", fmt.Sprintf("error closing connection to %s", p.clientName), "addr", addr, "err", err)
+		go p.closeClient(addr, client)
+	}
+}
+
+// touch records the use of the client for addr. The caller must hold the lock, for
+// reading at least.
+func (p *Pool) touch(addr string) {
+	if lastUsed, ok := p.lastUsed[addr]; ok {
+		lastUsed.Store(time.Now().UnixNano())
+	}
+}
+
+// evictLocked removes the least recently used clients exceeding MaxConnections from the
+// pool, and returns them by address. The caller must hold the lock.
+func (p *Pool) evictLocked() map[string]PoolClient {
+	if p.cfg.MaxConnections <= 0 {
+		return nil
+	}
+
+	var evicted map[string]PoolClient
+	for len(p.clients) > p.cfg.MaxConnections {
+		oldestAddr, oldest := "", int64(0)
+		for addr := range p.clients {
+			if lastUsed := p.lastUsed[addr].Load(); oldestAddr == "" || lastUsed < oldest {
+				oldestAddr, oldest = addr, lastUsed
 			}
-		}(addr, client)
+		}
+
+		if evicted == nil {
+			evicted = map[string]PoolClient{}
+		}
+		evicted[oldestAddr] = p.clients[oldestAddr]
+		delete(p.clients, oldestAddr)
+		delete(p.lastUsed, oldestAddr)
+		if p.clientsMetric != nil {
+			p.clientsMetric.Add(-1)
+		}
 	}
+	return evicted
+}
+
+// closeClientWhenIdle closes the client once it has no in-flight calls, waiting at most
+// evictedClientDrainTimeout.
+func (p *Pool) closeClientWhenIdle(addr string, client PoolClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), evictedClientDrainTimeout)
+	defer cancel()
+
+	if err := p.inFlight.wait(ctx, addr); err != nil {
+		level.Warn(p.logger).Log("msg", fmt.Sprintf("closing evicted %s with calls still in flight", p.clientName), "addr", addr)
+	}
+	p.closeClient(addr, client)
+}
+
+func (p *Pool) closeClient(addr string, client PoolClient) {
+	if err := client.Close(); err != nil {
+		level.Error(p.logger).Log("msg", fmt.Sprintf("error closing connection to %s", p.clientName), "addr", addr, "err", err)
+	}
+}
+
+// Shutdown stops the pool from handing out clients, then closes each client once its
+// in-flight calls, tracked by UnaryClientInterceptor and StreamClientInterceptor, have
+// completed. If ctx is done before, the remaining clients are closed anyway, and an
+// error listing the addresses with calls still in flight is returned.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.Lock()
+	p.shutdown = true
+	p.Unlock()
+
+	var inFlight []string
+	for _, addr := range p.RegisteredAddresses() {
+		if err := p.inFlight.wait(ctx, addr); err != nil {
+			inFlight = append(inFlight, addr)
+		}
+
+		p.Lock()
+		client, ok := p.clients[addr]
+		if ok {
+			delete(p.clients, addr)
+			delete(p.lastUsed, addr)
+			if p.clientsMetric != nil {
+				p.clientsMetric.Add(-1)
+			}
+		}
+		p.Unlock()
+		if ok {
+			p.closeClient(addr, client)
+		}
+	}
+
+	if len(inFlight) > 0 {
+		return fmt.Errorf("%s client pool shut down with calls still in flight to: %s", p.clientName, strings.Join(inFlight, ", "))
+	}
+	return nil
+}
+
+// Drain retires the client for addr, without affecting the other clients: it's removed
+// from the pool, GetClientFor fails with ErrClientDraining for addr until it's closed, and
+// it's closed once its in-flight calls, tracked by UnaryClientInterceptor and
+// StreamClientInterceptor, have completed. If ctx is done before, the client is closed
+// anyway and an error is returned. Once the client is closed, GetClientFor creates a new
+// client for addr again. It's a no-op if there's no client for addr.
+func (p *Pool) Drain(ctx context.Context, addr string) error {
+	p.Lock()
+	client, ok := p.clients[addr]
+	if !ok {
+		p.Unlock()
+		return nil
+	}
+	delete(p.clients, addr)
+	delete(p.lastUsed, addr)
+	p.draining[addr] = struct{}{}
+	if p.clientsMetric != nil {
+		p.clientsMetric.Add(-1)
+	}
+	p.Unlock()
+
+	err := p.inFlight.wait(ctx, addr)
+	p.closeClient(addr, client)
+
+	p.Lock()
+	delete(p.draining, addr)
+	p.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("%s client for %s closed with calls still in flight: %w", p.clientName, addr, err)
+	}
+	return nil
 }
 
 // RegisteredAddresses returns all the service addresses for which there's an active client.
@@ -188,6 +459,45 @@ func (p *Pool) cleanUnhealthy() {
 	}
 }
 
+// warmupIdleClients health checks the clients which haven't been used for WarmupInterval
+// and have no calls in flight, and removes the ones failing it.
+func (p *Pool) warmupIdleClients() {
+	for _, addr := range p.RegisteredAddresses() {
+		client, ok := p.idleClient(addr)
+		if !ok {
+			continue
+		}
+		if err := healthCheck(client, p.warmupTimeout()); err != nil {
+			level.Warn(p.logger).Log("msg", fmt.Sprintf("removing idle %s failing warmup", p.clientName), "addr", addr, "reason", err)
+			p.RemoveClientFor(addr)
+		}
+	}
+}
+
+func (p *Pool) warmupTimeout() time.Duration {
+	if p.cfg.HealthCheckTimeout <= 0 {
+		return defaultWarmupTimeout
+	}
+	return p.cfg.HealthCheckTimeout
+}
+
+// idleClient returns the client for addr, if it hasn't been used for WarmupInterval and
+// has no calls in flight.
+func (p *Pool) idleClient(addr string) (PoolClient, bool) {
+	p.RLock()
+	client, ok := p.clients[addr]
+	lastUsed, tracked := p.lastUsed[addr]
+	p.RUnlock()
+	if !ok || !tracked {
+		return nil, false
+	}
+
+	if time.Since(time.Unix(0, lastUsed.Load())) < p.cfg.WarmupInterval || p.inFlight.count(addr) > 0 {
+		return nil, false
+	}
+	return client, true
+}
+
 // healthCheck will check if the client is still healthy, returning an error if it is not
 func healthCheck(client PoolClient, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
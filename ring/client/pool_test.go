@@ -3,15 +3,21 @@ package client
 import (
 	"context"
 	"fmt"
+	"net"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-kit/log"
 	"github.com/gogo/status"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
 
 	"github.com/grafana/dskit/services"
 )
@@ -145,3 +151,416 @@ func TestCleanUnhealthy(t *testing.T) {
 		}
 	}
 }
+
+func TestPoolSharesConcurrentDialsForTheSameAddress(t *testing.T) {
+	dials := atomic.NewInt32(0)
+	release := make(chan struct{})
+	factory := func(addr string) (PoolClient, error) {
+		dials.Inc()
+		<-release
+		return mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+	}
+
+	pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second, MaxConcurrentDials: 10}, nil, factory, nil, log.NewNopLogger())
+
+	requested := atomic.NewInt32(0)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			requested.Inc()
+			_, err := pool.GetClientFor("1")
+			assert.NoError(t, err)
+		}()
+	}
+
+	// All the goroutines request the client before it's created.
+	require.Eventually(t, func() bool {
+		return requested.Load() == 10 && dials.Load() == 1
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), dials.Load())
+	assert.Equal(t, 1, pool.Count())
+}
+
+func TestPoolMaxConcurrentDials(t *testing.T) {
+	for _, maxConcurrentDials := range []int{0, 1, 3} {
+		t.Run(fmt.Sprintf("max concurrent dials: %d", maxConcurrentDials), func(t *testing.T) {
+			var (
+				inFlight    = atomic.NewInt32(0)
+				maxInFlight = atomic.NewInt32(0)
+			)
+			factory := func(addr string) (PoolClient, error) {
+				current := inFlight.Inc()
+				defer inFlight.Dec()
+				for {
+					max := maxInFlight.Load()
+					if current <= max || maxInFlight.CAS(max, current) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				return mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+			}
+
+			pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second, MaxConcurrentDials: maxConcurrentDials}, nil, factory, nil, log.NewNopLogger())
+
+			wg := sync.WaitGroup{}
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(addr string) {
+					defer wg.Done()
+					_, err := pool.GetClientFor(addr)
+					assert.NoError(t, err)
+				}(fmt.Sprintf("addr-%d", i))
+			}
+			wg.Wait()
+
+			expectedMax := int32(maxConcurrentDials)
+			if expectedMax == 0 {
+				expectedMax = 1
+			}
+			assert.Equal(t, expectedMax, maxInFlight.Load())
+			assert.Equal(t, 10, pool.Count())
+		})
+	}
+}
+
+type closeTrackingClient struct {
+	mockClient
+	closed *atomic.Bool
+}
+
+func (c closeTrackingClient) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// startCallInFlight starts a call to addr going through the pool interceptor, and
+// returns once the call is in flight. The call completes when release is closed, and
+// its error is then sent to done.
+func startCallInFlight(t *testing.T, pool *Pool, addr string) (release chan<- struct{}, done <-chan error) {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	started, released := make(chan struct{}), make(chan struct{})
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		close(started)
+		<-released
+		return nil
+	}
+	callDone := make(chan error, 1)
+	go func() {
+		callDone <- pool.UnaryClientInterceptor()(context.Background(), "/test/method", nil, nil, conn, invoker)
+	}()
+	<-started
+	return released, callDone
+}
+
+func TestPoolShutdown(t *testing.T) {
+	for name, test := range map[string]struct {
+		drainWithinDeadline bool
+	}{
+		"in-flight calls complete within the deadline": {drainWithinDeadline: true},
+		"in-flight calls outlive the deadline":         {drainWithinDeadline: false},
+	} {
+		t.Run(name, func(t *testing.T) {
+			closed := atomic.NewBool(false)
+			factory := func(addr string) (PoolClient, error) {
+				return closeTrackingClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, closed: closed}, nil
+			}
+			pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second}, nil, factory, nil, log.NewNopLogger())
+
+			_, err := pool.GetClientFor("addr-1")
+			require.NoError(t, err)
+
+			// Simulate a call in flight on the connection to the pooled address.
+			release, callDone := startCallInFlight(t, pool, "addr-1")
+
+			if test.drainWithinDeadline {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				shutdownDone := make(chan error)
+				go func() {
+					shutdownDone <- pool.Shutdown(ctx)
+				}()
+
+				// Once shutting down, the pool waits for the in-flight call.
+				require.Eventually(t, func() bool {
+					_, err := pool.GetClientFor("addr-1")
+					return err == ErrPoolShutdown
+				}, time.Second, 10*time.Millisecond)
+				select {
+				case err := <-shutdownDone:
+					t.Fatalf("shutdown should wait for the in-flight call, returned: %v", err)
+				default:
+				}
+				assert.False(t, closed.Load())
+
+				close(release)
+				assert.NoError(t, <-shutdownDone)
+			} else {
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+				err = pool.Shutdown(ctx)
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "calls still in flight to: addr-1")
+				close(release)
+			}
+			assert.NoError(t, <-callDone)
+
+			// The client is closed either way, and no new client is handed out.
+			assert.True(t, closed.Load())
+			assert.Equal(t, 0, pool.Count())
+			_, err = pool.GetClientFor("addr-1")
+			assert.Equal(t, ErrPoolShutdown, err)
+		})
+	}
+}
+
+func TestPoolShutdownWithCanceledStream(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	factory := func(addr string) (PoolClient, error) {
+		return mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+	}
+	pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second}, nil, factory, nil, log.NewNopLogger())
+	_, err := pool.GetClientFor("addr-1")
+	require.NoError(t, err)
+
+	conn, err := grpc.Dial("addr-1", grpc.WithInsecure(), grpc.WithStreamInterceptor(pool.StreamClientInterceptor()), grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return listener.Dial()
+	}))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The stream is canceled without being consumed.
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = grpc_health_v1.NewHealthClient(conn).Watch(ctx, &grpc_health_v1.HealthCheckRequest{})
+	require.NoError(t, err)
+	cancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	start := time.Now()
+	require.NoError(t, pool.Shutdown(shutdownCtx))
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestPoolDrain(t *testing.T) {
+	closed := map[string]*atomic.Bool{}
+	factory := func(addr string) (PoolClient, error) {
+		closed[addr] = atomic.NewBool(false)
+		return closeTrackingClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, closed: closed[addr]}, nil
+	}
+	pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second}, nil, factory, nil, log.NewNopLogger())
+
+	_, err := pool.GetClientFor("addr-1")
+	require.NoError(t, err)
+	other, err := pool.GetClientFor("addr-2")
+	require.NoError(t, err)
+
+	// Simulate a call in flight on the connection to addr-1.
+	release, callDone := startCallInFlight(t, pool, "addr-1")
+
+	drained := make(chan error)
+	go func() {
+		drained <- pool.Drain(context.Background(), "addr-1")
+	}()
+
+	// While the call is in flight, addr-1 is draining and isn't handed out.
+	require.Eventually(t, func() bool {
+		_, err := pool.GetClientFor("addr-1")
+		return err == ErrClientDraining
+	}, time.Second, 10*time.Millisecond)
+	assert.False(t, closed["addr-1"].Load())
+	assert.Equal(t, []string{"addr-2"}, pool.RegisteredAddresses())
+
+	close(release)
+	assert.NoError(t, <-callDone)
+	require.NoError(t, <-drained)
+	assert.True(t, closed["addr-1"].Load())
+
+	// The other connection isn't affected.
+	assert.False(t, closed["addr-2"].Load())
+	client, err := pool.GetClientFor("addr-2")
+	require.NoError(t, err)
+	assert.Equal(t, other, client)
+
+	// Once closed, a new client can be created for the drained address.
+	_, err = pool.GetClientFor("addr-1")
+	require.NoError(t, err)
+	assert.False(t, closed["addr-1"].Load())
+	assert.Equal(t, 2, pool.Count())
+}
+
+func TestPoolDrainOutlivingTheDeadline(t *testing.T) {
+	closed := atomic.NewBool(false)
+	factory := func(addr string) (PoolClient, error) {
+		return closeTrackingClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, closed: closed}, nil
+	}
+	pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second}, nil, factory, nil, log.NewNopLogger())
+
+	_, err := pool.GetClientFor("addr-1")
+	require.NoError(t, err)
+
+	release, callDone := startCallInFlight(t, pool, "addr-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = pool.Drain(ctx, "addr-1")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "client for addr-1 closed with calls still in flight")
+	assert.True(t, closed.Load())
+
+	close(release)
+	assert.NoError(t, <-callDone)
+
+	// Draining an address without a client is a no-op.
+	assert.NoError(t, pool.Drain(context.Background(), "addr-2"))
+}
+
+func TestPoolMaxConnectionsEvictsLeastRecentlyUsed(t *testing.T) {
+	closed := map[string]*atomic.Bool{}
+	factory := func(addr string) (PoolClient, error) {
+		closed[addr] = atomic.NewBool(false)
+		return closeTrackingClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, closed: closed[addr]}, nil
+	}
+	pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second, MaxConnections: 2}, nil, factory, nil, log.NewNopLogger())
+
+	get := func(addr string) {
+		_, err := pool.GetClientFor(addr)
+		require.NoError(t, err)
+	}
+
+	get("addr-1")
+	get("addr-2")
+	// Using addr-1 again makes addr-2 the least recently used.
+	get("addr-1")
+	get("addr-3")
+	assert.ElementsMatch(t, []string{"addr-1", "addr-3"}, pool.RegisteredAddresses())
+	assert.Eventually(t, closed["addr-2"].Load, time.Second, 10*time.Millisecond)
+
+	get("addr-4")
+	assert.ElementsMatch(t, []string{"addr-3", "addr-4"}, pool.RegisteredAddresses())
+	assert.Eventually(t, closed["addr-1"].Load, time.Second, 10*time.Millisecond)
+
+	assert.False(t, closed["addr-3"].Load())
+	assert.False(t, closed["addr-4"].Load())
+}
+
+func TestPoolEvictedClientIsClosedAfterInFlightCalls(t *testing.T) {
+	closed := atomic.NewBool(false)
+	factory := func(addr string) (PoolClient, error) {
+		if addr == "addr-1" {
+			return closeTrackingClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, closed: closed}, nil
+		}
+		return mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
+	}
+	pool := NewPool("test", PoolConfig{CheckInterval: 10 * time.Second, MaxConnections: 1}, nil, factory, nil, log.NewNopLogger())
+
+	_, err := pool.GetClientFor("addr-1")
+	require.NoError(t, err)
+
+	// Simulate a call in flight on the connection to addr-1.
+	release, callDone := startCallInFlight(t, pool, "addr-1")
+
+	_, err = pool.GetClientFor("addr-2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"addr-2"}, pool.RegisteredAddresses())
+
+	assert.Never(t, closed.Load, 50*time.Millisecond, 10*time.Millisecond, "the evicted client should not be closed while a call is in flight")
+
+	close(release)
+	assert.NoError(t, <-callDone)
+	assert.Eventually(t, closed.Load, time.Second, 10*time.Millisecond)
+}
+
+type checkCountingClient struct {
+	mockClient
+	checks *atomic.Int32
+}
+
+func (c checkCountingClient) Check(ctx context.Context, in *grpc_health_v1.HealthCheckRequest, opts ...grpc.CallOption) (*grpc_health_v1.HealthCheckResponse, error) {
+	c.checks.Inc()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.mockClient.Check(ctx, in, opts...)
+}
+
+func TestPoolWarmupIdleClients(t *testing.T) {
+	checks := map[string]*atomic.Int32{}
+	factory := func(addr string) (PoolClient, error) {
+		checks[addr] = atomic.NewInt32(0)
+		return checkCountingClient{mockClient: mockClient{happy: addr != "addr-unhealthy", status: grpc_health_v1.HealthCheckResponse_SERVING}, checks: checks[addr]}, nil
+	}
+	cfg := PoolConfig{CheckInterval: 10 * time.Second, HealthCheckTimeout: 50 * time.Millisecond, WarmupInterval: time.Minute}
+	pool := NewPool("test", cfg, nil, factory, nil, log.NewNopLogger())
+
+	for _, addr := range []string{"addr-idle", "addr-used", "addr-busy", "addr-unhealthy"} {
+		_, err := pool.GetClientFor(addr)
+		require.NoError(t, err)
+	}
+	// All the clients but addr-used haven't been used for longer than the interval, and
+	// addr-busy has a call in flight.
+	for _, addr := range []string{"addr-idle", "addr-busy", "addr-unhealthy"} {
+		pool.lastUsed[addr].Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	}
+	pool.inFlight.inc("addr-busy")
+
+	pool.warmupIdleClients()
+	assert.Equal(t, int32(1), checks["addr-idle"].Load())
+	assert.Equal(t, int32(0), checks["addr-used"].Load())
+	assert.Equal(t, int32(0), checks["addr-busy"].Load())
+	assert.Equal(t, int32(1), checks["addr-unhealthy"].Load())
+
+	// The client failing the warmup is removed.
+	assert.ElementsMatch(t, []string{"addr-idle", "addr-used", "addr-busy"}, pool.RegisteredAddresses())
+}
+
+func TestPoolWarmupWithoutHealthCheckTimeout(t *testing.T) {
+	checks := atomic.NewInt32(0)
+	factory := func(addr string) (PoolClient, error) {
+		return checkCountingClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, checks: checks}, nil
+	}
+	// The health check timeout is usually left unset with the health checks disabled.
+	cfg := PoolConfig{CheckInterval: 10 * time.Second, HealthCheckEnabled: false, HealthCheckTimeout: 0, WarmupInterval: time.Minute}
+	pool := NewPool("test", cfg, nil, factory, nil, log.NewNopLogger())
+	assert.Equal(t, defaultWarmupTimeout, pool.warmupTimeout())
+
+	_, err := pool.GetClientFor("addr-idle")
+	require.NoError(t, err)
+	pool.lastUsed["addr-idle"].Store(time.Now().Add(-2 * time.Minute).UnixNano())
+
+	// The healthy idle client passes the warmup instead of timing out at once.
+	pool.warmupIdleClients()
+	assert.Equal(t, int32(1), checks.Load())
+	assert.Equal(t, []string{"addr-idle"}, pool.RegisteredAddresses())
+}
+
+func TestPoolWarmupInterval(t *testing.T) {
+	checks := atomic.NewInt32(0)
+	factory := func(addr string) (PoolClient, error) {
+		return checkCountingClient{mockClient: mockClient{happy: true, status: grpc_health_v1.HealthCheckResponse_SERVING}, checks: checks}, nil
+	}
+	cfg := PoolConfig{CheckInterval: 10 * time.Second, HealthCheckTimeout: 50 * time.Millisecond, WarmupInterval: 10 * time.Millisecond}
+	pool := NewPool("test", cfg, nil, factory, nil, log.NewNopLogger())
+	require.NoError(t, services.StartAndAwaitRunning(context.Background(), pool))
+	defer services.StopAndAwaitTerminated(context.Background(), pool) //nolint:errcheck
+
+	_, err := pool.GetClientFor("addr-1")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return checks.Load() >= 2 }, time.Second, 10*time.Millisecond)
+}